@@ -32,6 +32,7 @@ const (
 	IsNull    = "IS NULL"
 	IsNotNull = "IS NOT NULL"
 	Between   = "BETWEEN"
+	Exists    = "EXISTS"
 	Desc      = "DESC"
 	Asc       = "ASC"
 	As        = "AS"
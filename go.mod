@@ -3,6 +3,7 @@ module github.com/acmestack/gorm-plus
 go 1.18
 
 require (
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/mysql v1.4.4
 	gorm.io/gorm v1.24.2
 )
@@ -0,0 +1,253 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	breakerStartTimeKey = "gplus:breaker_start_time"
+	breakerCancelKey    = "gplus:breaker_cancel"
+	breakerKey          = "gplus:breaker"
+)
+
+// ErrCircuitOpen 熔断器打开时直接返回该错误，不再访问数据库
+var ErrCircuitOpen = errors.New("gplus: circuit breaker is open")
+
+// CircuitBreakerConfig 熔断配置
+type CircuitBreakerConfig struct {
+	Timeout       time.Duration // 单条 SQL 的超时时间，0 表示不限制
+	SlowThreshold time.Duration // 执行耗时超过该值视为失败，0 表示不统计慢查询
+	FailureRate   float64       // 统计窗口内失败率达到该值时熔断，取值 (0, 1]
+	MinRequests   int           // 统计窗口内请求数达到该值才会计算失败率
+	Window        time.Duration // 统计窗口
+	OpenDuration  time.Duration // 熔断持续时间，结束后放行一个探测请求
+}
+
+// CircuitBreakerPlugin 熔断 gorm 插件，通过 db.Use(gplus.NewCircuitBreakerPlugin(config)) 注册
+// 默认整个数据源共用一个熔断器，PerEntity 为 true 时每张表单独熔断，Entities 可以为指定表设置单独的配置
+type CircuitBreakerPlugin struct {
+	Config    CircuitBreakerConfig
+	PerEntity bool
+	Entities  map[string]CircuitBreakerConfig
+
+	breakers sync.Map
+}
+
+// NewCircuitBreakerPlugin 创建熔断插件，未设置的配置使用默认值
+func NewCircuitBreakerPlugin(config CircuitBreakerConfig) *CircuitBreakerPlugin {
+	return &CircuitBreakerPlugin{Config: config}
+}
+
+func (p *CircuitBreakerPlugin) Name() string {
+	return "gplus:circuit_breaker"
+}
+
+func (p *CircuitBreakerPlugin) Initialize(db *gorm.DB) error {
+	return registerAroundCallbacks(db, "gplus:breaker", p.before, p.after)
+}
+
+func (p *CircuitBreakerPlugin) before(db *gorm.DB) {
+	// 执行前已经出错（例如参数校验失败）的 SQL 不会访问数据库，不参与统计
+	if db.Error != nil {
+		return
+	}
+	breaker := p.getBreaker(db.Statement.Table)
+	if !breaker.allow() {
+		db.AddError(fmt.Errorf("%w: %s", ErrCircuitOpen, breaker.name))
+		return
+	}
+	db.InstanceSet(breakerKey, breaker)
+	db.InstanceSet(breakerStartTimeKey, time.Now())
+
+	if breaker.config.Timeout > 0 {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(ctx, breaker.config.Timeout)
+		db.Statement.Context = ctx
+		db.InstanceSet(breakerCancelKey, cancel)
+	}
+}
+
+func (p *CircuitBreakerPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(breakerKey)
+	if !ok {
+		return
+	}
+	breaker := value.(*circuitBreaker)
+
+	var duration time.Duration
+	if startTime, ok := db.InstanceGet(breakerStartTimeKey); ok {
+		duration = time.Since(startTime.(time.Time))
+	}
+	failed := isConnectionError(db.Error) || (breaker.config.SlowThreshold > 0 && duration > breaker.config.SlowThreshold)
+	breaker.record(failed)
+
+	// Row、Rows 返回后调用方还需要读取结果，不能提前取消 Context，由超时自动释放
+	if cancel, ok := db.InstanceGet(breakerCancelKey); ok {
+		switch db.Statement.Dest.(type) {
+		case *sql.Row, *sql.Rows:
+		default:
+			cancel.(context.CancelFunc)()
+		}
+	}
+}
+
+// getBreaker 获取熔断器，按数据源熔断时所有表共用一个熔断器
+func (p *CircuitBreakerPlugin) getBreaker(table string) *circuitBreaker {
+	config, isEntity := p.Entities[table]
+	name := table
+	if !isEntity {
+		config = p.Config
+		if !p.PerEntity {
+			name = ""
+		}
+	}
+	if breaker, ok := p.breakers.Load(name); ok {
+		return breaker.(*circuitBreaker)
+	}
+	breaker, _ := p.breakers.LoadOrStore(name, newCircuitBreaker(name, config))
+	return breaker.(*circuitBreaker)
+}
+
+// isConnectionError 判断是否是连接异常、超时等数据库层面的错误，熔断只统计这类错误，
+// 记录不存在、唯一键冲突等业务错误不代表数据库异常
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// go-sql-driver/mysql 连接中断时返回的错误
+	return strings.Contains(err.Error(), "invalid connection")
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	name   string
+	config CircuitBreakerConfig
+
+	mu          sync.Mutex
+	state       breakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+func newCircuitBreaker(name string, config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureRate <= 0 || config.FailureRate > 1 {
+		config.FailureRate = 0.5
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = 20
+	}
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = 5 * time.Second
+	}
+	return &circuitBreaker{name: name, config: config, windowStart: time.Now()}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.config.OpenDuration {
+			return false
+		}
+		// 熔断时间结束，进入半开状态，放行一个探测请求
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *circuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+
+	if b.state == breakerHalfOpen {
+		b.probing = false
+		if failed {
+			b.state = breakerOpen
+			b.openedAt = now
+		} else {
+			b.state = breakerClosed
+			b.resetWindow(now)
+		}
+		return
+	}
+
+	if now.Sub(b.windowStart) > b.config.Window {
+		b.resetWindow(now)
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+	if b.requests >= b.config.MinRequests && float64(b.failures)/float64(b.requests) >= b.config.FailureRate {
+		b.state = breakerOpen
+		b.openedAt = now
+		b.resetWindow(now)
+	}
+}
+
+func (b *circuitBreaker) resetWindow(now time.Time) {
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+}
@@ -30,6 +30,9 @@ var columnNameCache sync.Map
 // 缓存实体对象，主要给NewQuery方法返回使用
 var modelInstanceCache sync.Map
 
+// 缓存实体解析后的 schema，用于获取索引、主键、软删除等元数据
+var schemaCache sync.Map
+
 // Cache 缓存实体对象所有的字段名
 func Cache(models ...any) {
 	for _, model := range models {
@@ -80,6 +83,11 @@ func GetModel[T any]() *T {
 	return t
 }
 
+// parseSchema 解析实体的 schema
+func parseSchema[T any]() (*schema.Schema, error) {
+	return schema.Parse(new(T), &schemaCache, GetDB().NamingStrategy)
+}
+
 // 递归获取嵌套字段名
 func getSubFieldColumnNameMap(valueOf reflect.Value, field reflect.StructField) map[uintptr]string {
 	result := make(map[uintptr]string)
@@ -111,7 +119,7 @@ func parseColumnName(field reflect.StructField) string {
 	if ok {
 		return name
 	}
-	return globalDb.Config.NamingStrategy.ColumnName("", field.Name)
+	return GetDB().Config.NamingStrategy.ColumnName("", field.Name)
 }
 
 func getColumnName(v any) string {
@@ -0,0 +1,465 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// EntityCache 实体缓存接口，key 为实体类型加主键值
+// 可以基于本地内存实现，也可以基于 Redis 等远程缓存实现，远程缓存需要自行处理序列化
+type EntityCache[T any] interface {
+	Get(key string) (*T, bool)
+	Set(key string, entity *T)
+	Delete(keys ...string)
+}
+
+// CachedDao 带实体缓存的 Dao，根据 ID 查询时优先读取缓存，根据 ID 或条件更新、删除时清除对应的缓存
+type CachedDao[T any] struct {
+	Dao[T]
+	caches []EntityCache[T]
+	flight singleFlight
+
+	// 通过 Tx 开启的事务中需要在提交后再次清除的缓存，key 为事务连接
+	pendingMu sync.Mutex
+	pending   map[gorm.ConnPool][]string
+
+	// 正在从数据库加载的 key，加载期间缓存被清除时不再写入加载到的旧数据
+	loadingMu sync.Mutex
+	loading   map[string]*cacheLoad
+}
+
+type cacheLoad struct {
+	refs       int
+	generation uint64
+}
+
+// NewCachedDao 创建带缓存的 Dao，caches 按顺序读取，例如先本地缓存再 Redis 缓存
+func NewCachedDao[T any](caches ...EntityCache[T]) *CachedDao[T] {
+	return &CachedDao[T]{caches: caches}
+}
+
+// SelectById 根据 ID 查询单条记录，未命中缓存时查询数据库并写入缓存，相同 ID 的并发查询只会访问一次数据库
+func (dao *CachedDao[T]) SelectById(id any, opts ...OptionFunc) (*T, *gorm.DB) {
+	if !dao.cacheable(opts) {
+		return SelectById[T](id, opts...)
+	}
+
+	key := dao.cacheKey(id)
+	if entity, ok := dao.get(key); ok {
+		resultDb := getDb(opts...)
+		resultDb.RowsAffected = 1
+		return entity, resultDb
+	}
+
+	result := dao.flight.do(key, func() any {
+		generation := dao.beginLoad(key)
+		entity, resultDb := SelectById[T](id, opts...)
+		dao.endLoad(key, generation, entity, resultDb.Error == nil)
+		return &cachedResult[T]{entity: entity, err: resultDb.Error, rowsAffected: resultDb.RowsAffected}
+	}).(*cachedResult[T])
+
+	// 合并的查询因为其他调用方的 Context 取消或超时失败时，使用自己的 Context 重新查询
+	option := getOption(opts)
+	if isContextError(result.err) && (option.Context == nil || option.Context.Err() == nil) {
+		return SelectById[T](id, opts...)
+	}
+	resultDb := getDb(opts...)
+	if result.err != nil {
+		resultDb.AddError(result.err)
+	}
+	resultDb.RowsAffected = result.rowsAffected
+	return copyEntity(result.entity), resultDb
+}
+
+// SelectByIds 根据 ID 查询多条记录，只查询未命中缓存的 ID，返回结果按照传入的 ID 顺序排列
+// 使用 CaptureMissingIds 选项时可以通过 GetMissingIds 获取缓存和数据库中都不存在的 ID
+func (dao *CachedDao[T]) SelectByIds(ids any, opts ...OptionFunc) ([]*T, *gorm.DB) {
+	if !dao.cacheable(opts) {
+		return SelectByIds[T](ids, opts...)
+	}
+
+	idValues := reflect.ValueOf(ids)
+	if idValues.Kind() != reflect.Slice && idValues.Kind() != reflect.Array {
+		return SelectByIds[T](ids, opts...)
+	}
+
+	var entities []*T
+	var missIds []any
+	for i := 0; i < idValues.Len(); i++ {
+		id := idValues.Index(i).Interface()
+		if entity, ok := dao.get(dao.cacheKey(id)); ok {
+			entities = append(entities, entity)
+		} else {
+			missIds = append(missIds, id)
+		}
+	}
+
+	resultDb := getDb(opts...)
+	if len(missIds) > 0 {
+		generations := make(map[string]uint64, len(missIds))
+		for _, id := range missIds {
+			key := dao.cacheKey(id)
+			if _, ok := generations[key]; !ok {
+				generations[key] = dao.beginLoad(key)
+			}
+		}
+		var missEntities []*T
+		missEntities, resultDb = SelectByIds[T](missIds, opts...)
+		loaded := make(map[string]*T, len(missEntities))
+		for _, entity := range missEntities {
+			loaded[dao.cacheKey(pkValue(entity))] = entity
+		}
+		for key, generation := range generations {
+			entity, ok := loaded[key]
+			dao.endLoad(key, generation, entity, ok && resultDb.Error == nil)
+		}
+		if resultDb.Error != nil {
+			return nil, resultDb
+		}
+		entities = append(entities, missEntities...)
+	}
+
+	option := getOption(opts)
+	option.OrderByIds = true
+	entities = arrangeByIds(ids, entities, option, resultDb)
+	// 与 SelectById 保持一致，RowsAffected 为返回的记录数，不论是否命中缓存
+	resultDb.RowsAffected = int64(len(entities))
+	return entities, resultDb
+}
+
+// UpdateById 根据 ID 更新,默认零值不更新，更新后清除缓存
+func (dao *CachedDao[T]) UpdateById(entity *T, opts ...OptionFunc) *gorm.DB {
+	resultDb := UpdateById[T](entity, opts...)
+	dao.evict(opts, pkValue(entity))
+	return resultDb
+}
+
+// UpdateZeroById 根据 ID 零值更新，更新后清除缓存
+func (dao *CachedDao[T]) UpdateZeroById(entity *T, opts ...OptionFunc) *gorm.DB {
+	resultDb := UpdateZeroById[T](entity, opts...)
+	dao.evict(opts, pkValue(entity))
+	return resultDb
+}
+
+// Update 根据 Map 更新，更新后清除受影响记录的缓存
+func (dao *CachedDao[T]) Update(q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
+	resultDb := Update[T](q, append(append([]OptionFunc{}, opts...), CaptureAffectedIds())...)
+	dao.evict(opts, GetAffectedIds(resultDb)...)
+	return resultDb
+}
+
+// UpdateColumnByIds 根据 ID 批量更新单个字段，更新后清除缓存
+func (dao *CachedDao[T]) UpdateColumnByIds(ids any, column any, value any, opts ...OptionFunc) *gorm.DB {
+	resultDb := UpdateColumnByIds[T](ids, column, value, opts...)
+	dao.evictIds(opts, ids)
+	return resultDb
+}
+
+// IncrementByIds 根据 ID 批量自增字段，更新后清除缓存
+func (dao *CachedDao[T]) IncrementByIds(ids any, column any, step any, opts ...OptionFunc) *gorm.DB {
+	resultDb := IncrementByIds[T](ids, column, step, opts...)
+	dao.evictIds(opts, ids)
+	return resultDb
+}
+
+// DeleteById 根据 ID 删除记录，删除后清除缓存
+func (dao *CachedDao[T]) DeleteById(id any, opts ...OptionFunc) *gorm.DB {
+	resultDb := DeleteById[T](id, opts...)
+	dao.evict(opts, id)
+	return resultDb
+}
+
+// DeleteByIds 根据 ID 批量删除记录，删除后清除缓存
+func (dao *CachedDao[T]) DeleteByIds(ids any, opts ...OptionFunc) *gorm.DB {
+	resultDb := DeleteByIds[T](ids, opts...)
+	dao.evictIds(opts, ids)
+	return resultDb
+}
+
+// Delete 根据条件删除记录，删除后清除受影响记录的缓存
+func (dao *CachedDao[T]) Delete(q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
+	resultDb := Delete[T](q, append(append([]OptionFunc{}, opts...), CaptureAffectedIds())...)
+	dao.evict(opts, GetAffectedIds(resultDb)...)
+	return resultDb
+}
+
+// Tx 开启事务，事务中通过 Db(tx) 执行的更新、删除会在提交后再次清除缓存，
+// 避免提交前其他请求把旧数据重新写入缓存
+func (dao *CachedDao[T]) Tx(txFunc func(tx *gorm.DB) error, opts ...OptionFunc) error {
+	var connPool gorm.ConnPool
+	err := getTxDb(opts).Transaction(func(tx *gorm.DB) error {
+		connPool = tx.Statement.ConnPool
+		dao.pendingMu.Lock()
+		if dao.pending == nil {
+			dao.pending = make(map[gorm.ConnPool][]string)
+		}
+		dao.pending[connPool] = nil
+		dao.pendingMu.Unlock()
+		return txFunc(tx)
+	})
+	if connPool == nil {
+		return err
+	}
+
+	dao.pendingMu.Lock()
+	keys := dao.pending[connPool]
+	delete(dao.pending, connPool)
+	dao.pendingMu.Unlock()
+	if err == nil {
+		dao.deleteKeys(keys)
+	}
+	return err
+}
+
+// cacheable 指定了查询或者忽略字段、绑定了角色时，查询到的只是部分字段，不能使用缓存
+// 指定了 Db 时可能在事务中，查询到的数据可能还没有提交，同样不能使用缓存
+func (dao *CachedDao[T]) cacheable(opts []OptionFunc) bool {
+	option := getOption(opts)
+	_, hasRole := GetRole(option.Context)
+	return len(option.Selects) == 0 && len(option.Omits) == 0 && !hasRole && option.Db == nil
+}
+
+func (dao *CachedDao[T]) cacheKey(id any) string {
+	return fmt.Sprintf("%s:%v", reflect.TypeOf((*T)(nil)).Elem().String(), id)
+}
+
+func (dao *CachedDao[T]) get(key string) (*T, bool) {
+	for i, cache := range dao.caches {
+		if entity, ok := cache.Get(key); ok {
+			// 回填前面未命中的缓存
+			for j := 0; j < i; j++ {
+				dao.caches[j].Set(key, entity)
+			}
+			return copyEntity(entity), true
+		}
+	}
+	return nil, false
+}
+
+// beginLoad 记录开始从数据库加载 key，返回当前的版本
+func (dao *CachedDao[T]) beginLoad(key string) uint64 {
+	dao.loadingMu.Lock()
+	defer dao.loadingMu.Unlock()
+	if dao.loading == nil {
+		dao.loading = make(map[string]*cacheLoad)
+	}
+	load, ok := dao.loading[key]
+	if !ok {
+		load = &cacheLoad{}
+		dao.loading[key] = load
+	}
+	load.refs++
+	return load.generation
+}
+
+// endLoad 加载结束，store 为 true 且加载期间缓存没有被清除时写入缓存
+// 写入缓存时持有锁，保证写入和清除缓存不会交错执行
+func (dao *CachedDao[T]) endLoad(key string, generation uint64, entity *T, store bool) {
+	dao.loadingMu.Lock()
+	defer dao.loadingMu.Unlock()
+	load := dao.loading[key]
+	if store && load.generation == generation {
+		for _, cache := range dao.caches {
+			cache.Set(key, copyEntity(entity))
+		}
+	}
+	if load.refs--; load.refs == 0 {
+		delete(dao.loading, key)
+	}
+}
+
+// evict 清除缓存，在 Tx 开启的事务中执行时记录下来，提交后再次清除
+func (dao *CachedDao[T]) evict(opts []OptionFunc, ids ...any) {
+	if len(ids) == 0 {
+		return
+	}
+	var keys []string
+	for _, id := range ids {
+		keys = append(keys, dao.cacheKey(id))
+	}
+	dao.deleteKeys(keys)
+
+	if option := getOption(opts); option.Db != nil {
+		dao.pendingMu.Lock()
+		if pendingKeys, ok := dao.pending[option.Db.Statement.ConnPool]; ok {
+			dao.pending[option.Db.Statement.ConnPool] = append(pendingKeys, keys...)
+		}
+		dao.pendingMu.Unlock()
+	}
+}
+
+func (dao *CachedDao[T]) deleteKeys(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	dao.loadingMu.Lock()
+	for _, key := range keys {
+		if load, ok := dao.loading[key]; ok {
+			load.generation++
+		}
+	}
+	dao.loadingMu.Unlock()
+	for _, cache := range dao.caches {
+		cache.Delete(keys...)
+	}
+}
+
+func (dao *CachedDao[T]) evictIds(opts []OptionFunc, ids any) {
+	idValues := reflect.ValueOf(ids)
+	if idValues.Kind() != reflect.Slice && idValues.Kind() != reflect.Array {
+		dao.evict(opts, ids)
+		return
+	}
+	var values []any
+	for i := 0; i < idValues.Len(); i++ {
+		values = append(values, idValues.Index(i).Interface())
+	}
+	dao.evict(opts, values...)
+}
+
+// cachedResult 合并查询的结果，每个调用方根据结果创建自己的 Db
+type cachedResult[T any] struct {
+	entity       *T
+	err          error
+	rowsAffected int64
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func pkValue[T any](entity *T) any {
+	value, _ := getPkValue(entity)
+	return value
+}
+
+// copyEntity 复制实体，避免调用方修改缓存中的对象
+func copyEntity[T any](entity *T) *T {
+	if entity == nil {
+		return nil
+	}
+	e := *entity
+	return &e
+}
+
+// LocalCache 本地内存实体缓存，设置了有效期时每隔一个有效期在写入时清除一次过期的缓存
+type LocalCache[T any] struct {
+	ttl     time.Duration
+	entries sync.Map
+	sweepMu sync.Mutex
+	sweptAt time.Time
+}
+
+type localCacheEntry[T any] struct {
+	entity   *T
+	expireAt time.Time
+}
+
+// NewLocalCache 创建本地内存实体缓存，ttl 小于等于 0 时永不过期
+func NewLocalCache[T any](ttl time.Duration) *LocalCache[T] {
+	return &LocalCache[T]{ttl: ttl}
+}
+
+func (c *LocalCache[T]) Get(key string) (*T, bool) {
+	value, ok := c.entries.Load(key)
+	if !ok {
+		return nil, false
+	}
+	entry := value.(*localCacheEntry[T])
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		c.entries.Delete(key)
+		return nil, false
+	}
+	return entry.entity, true
+}
+
+func (c *LocalCache[T]) Set(key string, entity *T) {
+	entry := &localCacheEntry[T]{entity: entity}
+	if c.ttl > 0 {
+		entry.expireAt = time.Now().Add(c.ttl)
+		c.sweep()
+	}
+	c.entries.Store(key, entry)
+}
+
+// sweep 清除过期的缓存，距离上次清除不足一个有效期时跳过
+func (c *LocalCache[T]) sweep() {
+	c.sweepMu.Lock()
+	now := time.Now()
+	if now.Sub(c.sweptAt) < c.ttl {
+		c.sweepMu.Unlock()
+		return
+	}
+	c.sweptAt = now
+	c.sweepMu.Unlock()
+	c.entries.Range(func(key, value any) bool {
+		if now.After(value.(*localCacheEntry[T]).expireAt) {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
+
+func (c *LocalCache[T]) Delete(keys ...string) {
+	for _, key := range keys {
+		c.entries.Delete(key)
+	}
+}
+
+// singleFlight 合并相同 key 的并发调用，防止缓存击穿
+type singleFlight struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	wg  sync.WaitGroup
+	val any
+}
+
+func (f *singleFlight) do(key string, fn func() any) any {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*flightCall)
+	}
+	if c, ok := f.calls[key]; ok {
+		f.mu.Unlock()
+		c.wg.Wait()
+		return c.val
+	}
+	c := &flightCall{}
+	c.wg.Add(1)
+	f.calls[key] = c
+	f.mu.Unlock()
+
+	defer func() {
+		c.wg.Done()
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+	}()
+	c.val = fn()
+	return c.val
+}
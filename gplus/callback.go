@@ -0,0 +1,62 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import "gorm.io/gorm"
+
+type callbackRegister func(name string, fn func(*gorm.DB)) error
+
+// internalDryRunKey 标记 gplus 内部只用于生成 SQL 的 DryRun 查询，例如 CountCache 生成缓存键，插件不统计这类查询
+const internalDryRunKey = "gplus:internal_dry_run"
+
+// registerAroundCallbacks 在所有类型 SQL 回调链的最前和最后注册回调，回调名为 name_before_类型、name_after_类型
+// gplus 内部生成 SQL 的 DryRun 查询不会执行回调
+func registerAroundCallbacks(db *gorm.DB, name string, before func(*gorm.DB), after func(*gorm.DB)) error {
+	before, after = skipInternalDryRun(before), skipInternalDryRun(after)
+	callback := db.Callback()
+	registers := []struct {
+		kind   string
+		before callbackRegister
+		after  callbackRegister
+	}{
+		{"create", callback.Create().Before("*").Register, callback.Create().After("*").Register},
+		{"query", callback.Query().Before("*").Register, callback.Query().After("*").Register},
+		{"update", callback.Update().Before("*").Register, callback.Update().After("*").Register},
+		{"delete", callback.Delete().Before("*").Register, callback.Delete().After("*").Register},
+		{"row", callback.Row().Before("*").Register, callback.Row().After("*").Register},
+		{"raw", callback.Raw().Before("*").Register, callback.Raw().After("*").Register},
+	}
+	for _, register := range registers {
+		if err := register.before(name+"_before_"+register.kind, before); err != nil {
+			return err
+		}
+		if err := register.after(name+"_after_"+register.kind, after); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func skipInternalDryRun(fn func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		if _, ok := db.Get(internalDryRunKey); ok {
+			return
+		}
+		fn(db)
+	}
+}
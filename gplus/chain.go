@@ -0,0 +1,104 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"context"
+	"gorm.io/gorm"
+)
+
+// ChainStep 事务中执行的一个步骤，results 为之前步骤的执行结果，返回当前步骤的执行结果
+type ChainStep func(tx *gorm.DB, results []*gorm.DB) *gorm.DB
+
+// TxChain 在同一个事务中按顺序执行多个步骤，任意步骤出错时回滚并停止执行后续步骤
+type TxChain struct {
+	ctx   context.Context
+	opts  []OptionFunc
+	steps []ChainStep
+}
+
+// Chain 创建事务步骤链，例如：gplus.Chain(ctx).Then(gplus.InsertStep(order), gplus.UpdateStep(stockQuery)).Run()
+// opts 中只有 Db 选项生效，用于在指定的 Db 或者已有的事务中执行
+func Chain(ctx context.Context, opts ...OptionFunc) *TxChain {
+	return &TxChain{ctx: ctx, opts: opts}
+}
+
+// Then 追加需要执行的步骤
+func (c *TxChain) Then(steps ...ChainStep) *TxChain {
+	c.steps = append(c.steps, steps...)
+	return c
+}
+
+// Run 在事务中执行所有步骤，返回已执行步骤的结果
+func (c *TxChain) Run() ([]*gorm.DB, error) {
+	var results []*gorm.DB
+	opts := append(append([]OptionFunc{}, c.opts...), Context(c.ctx))
+	err := getTxDb(opts).Transaction(func(tx *gorm.DB) error {
+		for _, step := range c.steps {
+			resultDb := step(tx, results)
+			results = append(results, resultDb)
+			if resultDb.Error != nil {
+				return resultDb.Error
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// InsertStep 插入一条记录
+func InsertStep[T any](entity *T, opts ...OptionFunc) ChainStep {
+	return func(tx *gorm.DB, _ []*gorm.DB) *gorm.DB {
+		return Insert[T](entity, withTx(opts, tx)...)
+	}
+}
+
+// InsertBatchStep 批量插入多条记录
+func InsertBatchStep[T any](entities []*T, opts ...OptionFunc) ChainStep {
+	return func(tx *gorm.DB, _ []*gorm.DB) *gorm.DB {
+		return InsertBatch[T](entities, withTx(opts, tx)...)
+	}
+}
+
+// UpdateByIdStep 根据 ID 更新,默认零值不更新
+func UpdateByIdStep[T any](entity *T, opts ...OptionFunc) ChainStep {
+	return func(tx *gorm.DB, _ []*gorm.DB) *gorm.DB {
+		return UpdateById[T](entity, withTx(opts, tx)...)
+	}
+}
+
+// UpdateStep 根据 Map 更新
+func UpdateStep[T any](q *QueryCond[T], opts ...OptionFunc) ChainStep {
+	return func(tx *gorm.DB, _ []*gorm.DB) *gorm.DB {
+		return Update[T](q, withTx(opts, tx)...)
+	}
+}
+
+// DeleteByIdStep 根据 ID 删除记录
+func DeleteByIdStep[T any](id any, opts ...OptionFunc) ChainStep {
+	return func(tx *gorm.DB, _ []*gorm.DB) *gorm.DB {
+		return DeleteById[T](id, withTx(opts, tx)...)
+	}
+}
+
+// DeleteStep 根据条件删除记录
+func DeleteStep[T any](q *QueryCond[T], opts ...OptionFunc) ChainStep {
+	return func(tx *gorm.DB, _ []*gorm.DB) *gorm.DB {
+		return Delete[T](q, withTx(opts, tx)...)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"time"
+)
+
+const changesKey = "gplus:changes"
+
+// FieldChange 字段变更前后的值
+type FieldChange struct {
+	Old any
+	New any
+}
+
+// GetChanges 获取 UpdateById 实际更新的字段，key 为字段名，需要配合 TrackChanges 使用
+func GetChanges(db *gorm.DB) map[string]FieldChange {
+	if changes, ok := db.InstanceGet(changesKey); ok {
+		return changes.(map[string]FieldChange)
+	}
+	return nil
+}
+
+// updateChangedById 在事务中锁定并查询当前记录，对比非零值字段，只更新发生变化的字段
+func updateChangedById[T any](entity *T, opts ...OptionFunc) *gorm.DB {
+	option := getOption(opts)
+	s, err := parseSchema[T]()
+	if err == nil && s.PrioritizedPrimaryField == nil {
+		err = gorm.ErrPrimaryKeyRequired
+	}
+	if err != nil {
+		resultDb := getDb(opts...)
+		resultDb.AddError(err)
+		return resultDb
+	}
+
+	selects := columnNameSet(option.Selects)
+	omits := columnNameSet(option.Omits)
+	changes := make(map[string]FieldChange)
+	resultDb := runInTx(opts, func(tx *gorm.DB) (*gorm.DB, error) {
+		ctx := tx.Statement.Context
+		entityValue := reflect.ValueOf(entity).Elem()
+		pkValue, _ := s.PrioritizedPrimaryField.ValueOf(ctx, entityValue)
+
+		current := new(T)
+		findDb := tx.Model(new(T)).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: clause.PrimaryKey}, Value: pkValue}).
+			Take(current)
+		if findDb.Error != nil {
+			return findDb, findDb.Error
+		}
+		currentValue := reflect.ValueOf(current).Elem()
+
+		var columns []string
+		for _, field := range s.Fields {
+			if field.DBName == "" || field.PrimaryKey || !field.Updatable {
+				continue
+			}
+			if _, ok := omits[field.DBName]; ok {
+				continue
+			}
+			if _, ok := selects[field.DBName]; len(selects) > 0 && !ok {
+				continue
+			}
+			// 更新时间由 gorm 自动维护，不参与对比
+			if field.AutoUpdateTime > 0 {
+				continue
+			}
+			// 与 UpdateById 保持一致，零值不更新
+			newValue, isZero := field.ValueOf(ctx, entityValue)
+			if isZero {
+				continue
+			}
+			oldValue, _ := field.ValueOf(ctx, currentValue)
+			if !isValueEqual(oldValue, newValue) {
+				changes[field.DBName] = FieldChange{Old: oldValue, New: newValue}
+				columns = append(columns, field.DBName)
+			}
+		}
+
+		txOpts := withTx(opts, tx)
+		updateDb := getDb(txOpts...)
+		if len(columns) == 0 {
+			return updateDb, nil
+		}
+		updateDb.Statement.Selects = nil
+		updateDb.Statement.Omits = nil
+		updateDb.Model(entity).Select(columns).Updates(entity)
+		return updateDb, updateDb.Error
+	})
+	resultDb.InstanceSet(changesKey, changes)
+	return resultDb
+}
+
+func columnNameSet(columns []any) map[string]struct{} {
+	names := make(map[string]struct{}, len(columns))
+	for _, column := range columns {
+		names[getColumnName(column)] = struct{}{}
+	}
+	return names
+}
+
+// isValueEqual 比较字段值，时间类型使用 Equal 比较，忽略时区和单调时钟的差异
+func isValueEqual(a, b any) bool {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Equal(tb)
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,305 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrColumnForbidden 当前角色没有可以查询的字段，或者查询了无法确认是否可见的字段，例如 *、表达式、原生 SQL
+var ErrColumnForbidden = errors.New("gplus: column forbidden for role")
+
+type roleContextKey struct{}
+
+// 缓存实体按角色注册的可见字段，key为实体类型，value为角色到字段集合的映射
+var columnPolicyCache sync.Map
+
+var columnPolicyMu sync.Mutex
+
+// RegisterColumnPolicy 注册角色可以查询的字段，实体注册过任意角色后，未注册的角色无法查询该实体
+func RegisterColumnPolicy[T any](role string, columns ...any) {
+	columnPolicyMu.Lock()
+	defer columnPolicyMu.Unlock()
+	modelType := reflect.TypeOf((*T)(nil)).Elem()
+	policies := make(map[string]map[string]struct{})
+	if value, ok := columnPolicyCache.Load(modelType); ok {
+		for r, names := range value.(map[string]map[string]struct{}) {
+			policies[r] = names
+		}
+	}
+	names := make(map[string]struct{}, len(columns))
+	for _, column := range columns {
+		names[getColumnName(column)] = struct{}{}
+	}
+	policies[role] = names
+	columnPolicyCache.Store(modelType, policies)
+}
+
+// WithRole 将调用方角色绑定到 Context，使用该 Context 查询时只返回角色可见的字段
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey{}, role)
+}
+
+// GetRole 获取 Context 绑定的角色
+func GetRole(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	role, ok := ctx.Value(roleContextKey{}).(string)
+	return role, ok
+}
+
+// ColumnPolicyPlugin 按角色限制查询字段的 gorm 插件，通过 db.Use(&gplus.ColumnPolicyPlugin{}) 注册
+// 查询前校验 SELECT 字段，只允许查询角色可见的字段，查询后清空结果中不可见字段的值
+// Scan、Rows 等查询在回调执行后才读取结果，无法清空结果，只依靠 SELECT 字段的校验
+type ColumnPolicyPlugin struct{}
+
+func (p *ColumnPolicyPlugin) Name() string {
+	return "gplus:column_policy"
+}
+
+func (p *ColumnPolicyPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	if err := callback.Query().Before("gorm:query").Register("gplus:column_policy_restrict", restrictColumns); err != nil {
+		return err
+	}
+	if err := callback.Row().Before("gorm:row").Register("gplus:column_policy_restrict", restrictColumns); err != nil {
+		return err
+	}
+	return callback.Query().After("gorm:query").Register("gplus:column_policy_strip", stripColumns)
+}
+
+// getVisibleColumns 获取当前查询角色可见的字段，实体没有注册字段策略或者没有绑定角色时 ok 返回 false
+func getVisibleColumns(db *gorm.DB) (s *schema.Schema, visible map[string]struct{}, ok bool) {
+	if _, hasRole := GetRole(db.Statement.Context); !hasRole {
+		return nil, nil, false
+	}
+	s = db.Statement.Schema
+	if s == nil {
+		// 只通过 Table 指定表名的查询，根据表名查找注册过字段策略的实体
+		if s = lookupPolicySchema(db); s == nil {
+			return nil, nil, false
+		}
+	}
+	value, hasPolicy := columnPolicyCache.Load(s.ModelType)
+	if !hasPolicy {
+		return nil, nil, false
+	}
+	role, _ := GetRole(db.Statement.Context)
+	return s, value.(map[string]map[string]struct{})[role], true
+}
+
+// lookupPolicySchema 根据表名查找注册过字段策略的实体
+func lookupPolicySchema(db *gorm.DB) *schema.Schema {
+	table := trimQuotes(db.Statement.Table)
+	if index := strings.IndexByte(table, ' '); index >= 0 {
+		table = table[:index]
+	}
+	if table == "" {
+		return nil
+	}
+	var found *schema.Schema
+	columnPolicyCache.Range(func(key, _ any) bool {
+		s, err := schema.Parse(reflect.New(key.(reflect.Type)).Interface(), &schemaCache, db.NamingStrategy)
+		if err == nil && s.Table == table {
+			found = s
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+func restrictColumns(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	s, visible, ok := getVisibleColumns(db)
+	if !ok {
+		return
+	}
+	stmt := db.Statement
+	// 原生 SQL 无法确认查询了哪些字段
+	if stmt.SQL.Len() > 0 {
+		forbidColumn(db, s, "raw sql")
+		return
+	}
+	// Count、Pluck 以及带参数的 Select 通过 SELECT 子句指定查询字段，此时 Selects 不生效
+	if selectClause, ok := stmt.Clauses["SELECT"]; ok && selectClause.Expression != nil {
+		if column, ok := isVisibleSelectClause(stmt, s, visible, selectClause.Expression); !ok {
+			forbidColumn(db, s, column)
+		}
+		return
+	}
+
+	var selects []string
+	if len(stmt.Selects) == 0 {
+		omits := make(map[string]struct{}, len(stmt.Omits))
+		for _, column := range stmt.Omits {
+			if field := s.LookUpField(column); field != nil {
+				omits[field.DBName] = struct{}{}
+			}
+		}
+		for _, dbName := range s.DBNames {
+			_, isVisible := visible[dbName]
+			_, isOmitted := omits[dbName]
+			if isVisible && !isOmitted {
+				selects = append(selects, dbName)
+			}
+		}
+	} else {
+		for _, column := range stmt.Selects {
+			dbName, known := lookupSelectColumn(stmt, s, column)
+			if !known {
+				forbidColumn(db, s, column)
+				return
+			}
+			// 指定了不可见的字段时移除该字段
+			if _, ok := visible[dbName]; ok {
+				selects = append(selects, column)
+			}
+		}
+	}
+	if len(selects) == 0 {
+		forbidColumn(db, s, "")
+		return
+	}
+	stmt.Selects = selects
+}
+
+// lookupSelectColumn 获取 SELECT 字段对应的实体字段名，* 、表达式、其他表的字段等无法对应到实体字段时 known 返回 false
+func lookupSelectColumn(stmt *gorm.Statement, s *schema.Schema, column string) (dbName string, known bool) {
+	name := trimQuotes(column)
+	if index := strings.LastIndexByte(name, '.'); index >= 0 {
+		if table := name[:index]; table != s.Table && table != trimQuotes(stmt.Table) {
+			return "", false
+		}
+		name = name[index+1:]
+	}
+	field := s.LookUpField(name)
+	if field == nil || field.DBName == "" {
+		return "", false
+	}
+	return field.DBName, true
+}
+
+// isVisibleSelectClause 校验 SELECT 子句，只允许可见的字段和 COUNT，校验失败时返回不可见的字段
+func isVisibleSelectClause(stmt *gorm.Statement, s *schema.Schema, visible map[string]struct{}, expression clause.Expression) (string, bool) {
+	isVisible := func(column string) bool {
+		dbName, known := lookupSelectColumn(stmt, s, column)
+		_, ok := visible[dbName]
+		return known && ok
+	}
+	switch expr := expression.(type) {
+	case clause.Select:
+		if len(expr.Columns) == 0 {
+			return "*", false
+		}
+		for _, column := range expr.Columns {
+			if !column.Raw && column.Table != "" && column.Table != clause.CurrentTable {
+				return column.Table + "." + column.Name, false
+			}
+			if !isVisible(column.Name) {
+				return column.Name, false
+			}
+		}
+		return "", true
+	case clause.Expr:
+		// Count 生成的 SELECT 子句
+		sql := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(expr.SQL)), "distinct ")
+		switch {
+		case len(expr.Vars) == 0 && (sql == "count(*)" || sql == "count(1)"):
+			return "", true
+		case len(expr.Vars) == 1 && (sql == "count(?)" || sql == "count(distinct(?))"):
+			if column, ok := expr.Vars[0].(clause.Column); ok && isVisible(column.Name) {
+				return "", true
+			}
+		}
+		return expr.SQL, false
+	}
+	return fmt.Sprintf("%v", expression), false
+}
+
+func forbidColumn(db *gorm.DB, s *schema.Schema, column string) {
+	role, _ := GetRole(db.Statement.Context)
+	if column == "" {
+		db.AddError(fmt.Errorf("%w: %s on %s", ErrColumnForbidden, role, s.Table))
+		return
+	}
+	db.AddError(fmt.Errorf("%w: %s on %s, select %s", ErrColumnForbidden, role, s.Table, column))
+}
+
+func trimQuotes(column string) string {
+	return strings.NewReplacer("`", "", `"`, "").Replace(strings.TrimSpace(column))
+}
+
+func stripColumns(db *gorm.DB) {
+	if db.Statement.Dest == nil {
+		return
+	}
+	s, visible, ok := getVisibleColumns(db)
+	if !ok {
+		return
+	}
+	var hidden []string
+	for _, dbName := range s.DBNames {
+		if _, ok := visible[dbName]; !ok {
+			hidden = append(hidden, dbName)
+		}
+	}
+	if len(hidden) == 0 {
+		return
+	}
+
+	var strip func(rv reflect.Value)
+	strip = func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				strip(rv.Index(i))
+			}
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				return
+			}
+			for _, dbName := range hidden {
+				rv.SetMapIndex(reflect.ValueOf(dbName).Convert(rv.Type().Key()), reflect.Value{})
+			}
+		case reflect.Struct:
+			if rv.Type() != s.ModelType || !rv.CanSet() {
+				return
+			}
+			for _, dbName := range hidden {
+				field := s.FieldsByDBName[dbName]
+				field.ReflectValueOf(db.Statement.Context, rv).Set(reflect.Zero(field.FieldType))
+			}
+		case reflect.Interface:
+			strip(rv.Elem())
+		}
+	}
+	strip(reflect.ValueOf(db.Statement.Dest))
+}
@@ -0,0 +1,267 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// CountCache 缓存 SelectCount 的查询结果，键为 COUNT SQL，同一张表发生写操作时失效
+// 需要通过 db.Use 注册后才能感知写操作，查询时通过 WithCountCache 选项开启
+// 事务中的写操作需要通过 Tx 执行，提交后会再次清除缓存
+// Tips: 只根据主表失效，关联查询中其他表的写操作需要等待过期
+type CountCache struct {
+	TTL time.Duration
+	// MaxEntries 最多缓存的 COUNT SQL 数量，默认 1000，达到上限时先清除过期的缓存，仍然超出时随机淘汰一条
+	MaxEntries int
+	mu         sync.Mutex
+	tables     map[string]map[string]countEntry
+	size       int
+
+	// 清除缓存时增加版本，查询期间版本发生变化时不写入缓存
+	version       uint64
+	tableVersions map[string]uint64
+	// 通过 Tx 开启的事务中写入过的表，key 为事务连接，空表名表示无法确定表名
+	pending map[gorm.ConnPool]map[string]struct{}
+}
+
+type countVersion struct {
+	all   uint64
+	table uint64
+}
+
+const defaultCountCacheEntries = 1000
+
+type countEntry struct {
+	count    int64
+	expireAt time.Time
+}
+
+// NewCountCache 创建 COUNT 缓存，ttl 为缓存有效期
+func NewCountCache(ttl time.Duration) *CountCache {
+	return &CountCache{TTL: ttl, MaxEntries: defaultCountCacheEntries, tables: make(map[string]map[string]countEntry)}
+}
+
+// Name 插件名称
+func (c *CountCache) Name() string {
+	return "gplus:count_cache"
+}
+
+// Initialize 在写操作后清除对应表的缓存，Exec 执行的 SQL 无法确定表名，清除全部缓存
+func (c *CountCache) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	registers := []callbackRegister{
+		callback.Create().After("*").Register,
+		callback.Update().After("*").Register,
+		callback.Delete().After("*").Register,
+		callback.Raw().After("*").Register,
+	}
+	for _, register := range registers {
+		if err := register(c.Name(), c.invalidate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate 清除指定表的缓存，不传表名时清除全部缓存
+func (c *CountCache) Invalidate(tables ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(tables) == 0 {
+		c.tables = make(map[string]map[string]countEntry)
+		c.size = 0
+		c.version++
+		return
+	}
+	if c.tableVersions == nil {
+		c.tableVersions = make(map[string]uint64)
+	}
+	for _, table := range tables {
+		c.size -= len(c.tables[table])
+		delete(c.tables, table)
+		c.tableVersions[table]++
+	}
+}
+
+// Tx 开启事务，事务中的写操作在提交后再次清除缓存，事务结束前涉及的表不会写入缓存，
+// 避免提交前其他请求把旧的总数重新写入缓存
+func (c *CountCache) Tx(txFunc func(tx *gorm.DB) error, opts ...OptionFunc) error {
+	var connPool gorm.ConnPool
+	var nested bool
+	err := getTxDb(opts).Transaction(func(tx *gorm.DB) error {
+		connPool = tx.Statement.ConnPool
+		c.mu.Lock()
+		if c.pending == nil {
+			c.pending = make(map[gorm.ConnPool]map[string]struct{})
+		}
+		// 嵌套的事务由外层事务在提交后清除缓存
+		if _, nested = c.pending[connPool]; !nested {
+			c.pending[connPool] = make(map[string]struct{})
+		}
+		c.mu.Unlock()
+		return txFunc(tx)
+	})
+	if connPool == nil || nested {
+		return err
+	}
+
+	c.mu.Lock()
+	tables := c.pending[connPool]
+	delete(c.pending, connPool)
+	c.mu.Unlock()
+	if err != nil || len(tables) == 0 {
+		return err
+	}
+	if _, ok := tables[""]; ok {
+		c.Invalidate()
+		return nil
+	}
+	names := make([]string, 0, len(tables))
+	for table := range tables {
+		names = append(names, table)
+	}
+	c.Invalidate(names...)
+	return nil
+}
+
+func (c *CountCache) invalidate(db *gorm.DB) {
+	table := db.Statement.Table
+	c.mu.Lock()
+	if tables, ok := c.pending[db.Statement.ConnPool]; ok {
+		tables[table] = struct{}{}
+	}
+	c.mu.Unlock()
+	if table == "" {
+		c.Invalidate()
+		return
+	}
+	c.Invalidate(table)
+}
+
+// currentVersion 获取表当前的缓存版本，查询总数前获取，写入缓存时校验
+func (c *CountCache) currentVersion(table string) countVersion {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return countVersion{all: c.version, table: c.tableVersions[table]}
+}
+
+func (c *CountCache) get(table, key string) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.tables[table][key]
+	if !ok {
+		return 0, false
+	}
+	if time.Now().After(entry.expireAt) {
+		c.remove(table, key)
+		return 0, false
+	}
+	return entry.count, true
+}
+
+// set 写入缓存，查询期间缓存被清除或者表在未提交的事务中被修改时跳过
+func (c *CountCache) set(table, key string, count int64, version countVersion) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if version != (countVersion{all: c.version, table: c.tableVersions[table]}) {
+		return
+	}
+	for _, tables := range c.pending {
+		_, written := tables[table]
+		_, unknown := tables[""]
+		if written || unknown {
+			return
+		}
+	}
+	if _, ok := c.tables[table][key]; !ok {
+		c.evict()
+		c.size++
+	}
+	entries, ok := c.tables[table]
+	if !ok {
+		entries = make(map[string]countEntry)
+		c.tables[table] = entries
+	}
+	entries[key] = countEntry{count: count, expireAt: time.Now().Add(c.TTL)}
+}
+
+// evict 缓存数量达到上限时清除过期的缓存，仍然没有空位时随机淘汰一条，调用方需要持有锁
+func (c *CountCache) evict() {
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultCountCacheEntries
+	}
+	if c.size < maxEntries {
+		return
+	}
+	now := time.Now()
+	for table, entries := range c.tables {
+		for key, entry := range entries {
+			if now.After(entry.expireAt) {
+				c.remove(table, key)
+			}
+		}
+	}
+	for table, entries := range c.tables {
+		for key := range entries {
+			if c.size < maxEntries {
+				return
+			}
+			c.remove(table, key)
+		}
+	}
+}
+
+// remove 删除一条缓存，表没有缓存时一并删除，调用方需要持有锁
+func (c *CountCache) remove(table, key string) {
+	entries := c.tables[table]
+	if _, ok := entries[key]; !ok {
+		return
+	}
+	delete(entries, key)
+	c.size--
+	if len(entries) == 0 {
+		delete(c.tables, table)
+	}
+}
+
+// cachedCount 优先从缓存中获取总数，没有命中时执行 COUNT 并写入缓存
+func cachedCount[T any](cache *CountCache, db *gorm.DB) int64 {
+	var count int64
+	s, err := parseSchema[T]()
+	if err != nil {
+		db.AddError(err)
+		return count
+	}
+	version := cache.currentVersion(s.Table)
+	// 只用于生成缓存键，不需要统计、熔断等插件处理
+	key := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return tx.Set(internalDryRunKey, true).Count(&count)
+	})
+	if cached, ok := cache.get(s.Table, key); ok {
+		return cached
+	}
+	db.Count(&count)
+	if db.Error == nil {
+		cache.set(s.Table, key, count, version)
+	}
+	return count
+}
@@ -17,24 +17,50 @@
 package gplus
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/acmestack/gorm-plus/constants"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/schema"
 	"gorm.io/gorm/utils"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 )
 
 var globalDb *gorm.DB
+var globalDbMu sync.RWMutex
 var defaultBatchSize = 1000
 
+const affectedIdsKey = "gplus:affected_ids"
+const missingIdsKey = "gplus:missing_ids"
+
 func Init(db *gorm.DB) {
+	globalDbMu.Lock()
+	defer globalDbMu.Unlock()
 	globalDb = db
 }
 
+// ReplaceDB 运行时替换全局 Db，返回被替换的旧 Db，旧 Db 的连接池需要调用方自行关闭
+func ReplaceDB(db *gorm.DB) *gorm.DB {
+	globalDbMu.Lock()
+	defer globalDbMu.Unlock()
+	old := globalDb
+	globalDb = db
+	return old
+}
+
+// GetDB 获取当前的全局 Db
+func GetDB() *gorm.DB {
+	globalDbMu.RLock()
+	defer globalDbMu.RUnlock()
+	return globalDb
+}
+
 type Page[T any] struct {
 	Current    int   `json:"current"`
 	Size       int   `json:"size"`
@@ -112,8 +138,75 @@ func InsertBatchSize[T any](entities []*T, batchSize int, opts ...OptionFunc) *g
 	return resultDb
 }
 
+// InsertFailure 插入失败的记录，Index 为记录在传入切片中的下标
+type InsertFailure[T any] struct {
+	Index  int
+	Entity *T
+	Err    error
+}
+
+// InsertReport 批量插入的结果，Succeeded 按传入顺序保存插入成功的记录
+type InsertReport[T any] struct {
+	Succeeded []*T
+	Failed    []InsertFailure[T]
+}
+
+// InsertBatchPartial 批量插入多条记录，某一批插入失败时二分重试，直到定位出失败的记录，其他记录正常插入
+// 每次插入在单独的事务（已在事务中时为保存点）中执行，返回的 Db 的 RowsAffected 为插入成功的数量
+// 连接异常、超时、Context 取消等与记录无关的错误不再重试，直接停止插入并通过返回的 Db 的 Error 返回，未执行的记录不会出现在结果中
+func InsertBatchPartial[T any](entities []*T, opts ...OptionFunc) (*InsertReport[T], *gorm.DB) {
+	db := getDb(opts...)
+	report := &InsertReport[T]{}
+	for start := 0; start < len(entities); start += defaultBatchSize {
+		end := start + defaultBatchSize
+		if end > len(entities) {
+			end = len(entities)
+		}
+		if err := insertPartial(db, entities, start, end, report); err != nil {
+			db.AddError(err)
+			break
+		}
+	}
+	db.RowsAffected = int64(len(report.Succeeded))
+	return report, db
+}
+
+// insertPartial 插入 [start, end) 范围内的记录，失败时拆分为两半分别插入，与记录无关的错误直接返回
+func insertPartial[T any](db *gorm.DB, entities []*T, start, end int, report *InsertReport[T]) error {
+	batch := entities[start:end]
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(batch).Error
+	})
+	if err == nil {
+		report.Succeeded = append(report.Succeeded, batch...)
+		return nil
+	}
+	if !isRowError(err) {
+		return err
+	}
+	if len(batch) == 1 {
+		report.Failed = append(report.Failed, InsertFailure[T]{Index: start, Entity: batch[0], Err: err})
+		return nil
+	}
+	mid := start + len(batch)/2
+	if err = insertPartial(db, entities, start, mid, report); err != nil {
+		return err
+	}
+	return insertPartial(db, entities, mid, end, report)
+}
+
+// isRowError 判断插入失败是否可能由某条记录的数据引起，连接异常、超时、熔断等错误重试也无法成功
+func isRowError(err error) bool {
+	return !isConnectionError(err) && !errors.Is(err, context.Canceled) && !errors.Is(err, ErrCircuitOpen)
+}
+
 // DeleteById 根据 ID 删除记录
 func DeleteById[T any](id any, opts ...OptionFunc) *gorm.DB {
+	if getOption(opts).CaptureAffectedIds {
+		q, _ := NewQuery[T]()
+		q.Eq(getPkColumnName[T](), id)
+		return Delete[T](q, opts...)
+	}
 	db := getDb(opts...)
 	var entity T
 	resultDb := db.Where(getPkColumnName[T](), id).Delete(&entity)
@@ -130,19 +223,54 @@ func DeleteByIds[T any](ids any, opts ...OptionFunc) *gorm.DB {
 
 // Delete 根据条件删除记录
 func Delete[T any](q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
-	var entity T
-	resultDb := buildCondition[T](q, opts...)
-	resultDb.Delete(&entity)
-	return resultDb
+	return execWithAffectedIds[T](q, opts, func(q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
+		var entity T
+		resultDb := buildCondition[T](q, opts...)
+		resultDb.Delete(&entity)
+		return resultDb
+	})
+}
+
+// SaveAction Save 实际执行的操作
+type SaveAction int
+
+const (
+	SaveInserted SaveAction = iota + 1 // 插入
+	SaveUpdated                        // 更新
+)
+
+// Save 主键为零值时插入记录，否则根据 ID 更新，默认零值不更新，返回实际执行的操作
+func Save[T any](entity *T, opts ...OptionFunc) (SaveAction, *gorm.DB) {
+	if _, isZero := getPkValue(entity); isZero {
+		return SaveInserted, Insert[T](entity, opts...)
+	}
+	return SaveUpdated, UpdateById[T](entity, opts...)
 }
 
 // UpdateById 根据 ID 更新,默认零值不更新
 func UpdateById[T any](entity *T, opts ...OptionFunc) *gorm.DB {
+	option := getOption(opts)
+	if option.CaptureAffectedIds {
+		// 生成锁定条件后关闭 CaptureAffectedIds，事务中按原有逻辑更新
+		q, _ := NewQuery[T]()
+		id, _ := getPkValue(entity)
+		q.Eq(getPkColumnName[T](), id)
+		return execWithAffectedIds[T](q, opts, func(_ *QueryCond[T], txOpts ...OptionFunc) *gorm.DB {
+			return UpdateById[T](entity, append(txOpts, withoutCaptureAffectedIds)...)
+		})
+	}
+	if option.TrackChanges {
+		return updateChangedById[T](entity, opts...)
+	}
 	db := getDb(opts...)
 	resultDb := db.Model(entity).Updates(entity)
 	return resultDb
 }
 
+func withoutCaptureAffectedIds(o *Option) {
+	o.CaptureAffectedIds = false
+}
+
 // UpdateZeroById 根据 ID 零值更新
 func UpdateZeroById[T any](entity *T, opts ...OptionFunc) *gorm.DB {
 	db := getDb(opts...)
@@ -168,11 +296,112 @@ func updateAllIfNeed(entity any, opts []OptionFunc, db *gorm.DB) {
 
 // Update 根据 Map 更新
 func Update[T any](q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
-	resultDb := buildCondition[T](q, opts...)
-	resultDb.Updates(&q.updateMap)
+	return execWithAffectedIds[T](q, opts, func(q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
+		resultDb := buildCondition[T](q, opts...)
+		resultDb.Updates(&q.updateMap)
+		return resultDb
+	})
+}
+
+// UpdateColumnByIds 根据 ID 批量更新单个字段
+func UpdateColumnByIds[T any](ids any, column any, value any, opts ...OptionFunc) *gorm.DB {
+	q, _ := NewQuery[T]()
+	q.In(getPkColumnName[T](), ids).Set(column, value)
+	return Update[T](q, opts...)
+}
+
+// IncrementByIds 根据 ID 批量自增字段，step 为负数时自减
+func IncrementByIds[T any](ids any, column any, step any, opts ...OptionFunc) *gorm.DB {
+	columnName := getColumnName(column)
+	return UpdateColumnByIds[T](ids, columnName, gorm.Expr(columnName+" + ?", step), opts...)
+}
+
+// GetAffectedIds 获取 Update、Delete 影响的主键，需要配合 CaptureAffectedIds 使用
+func GetAffectedIds(db *gorm.DB) []any {
+	if ids, ok := db.InstanceGet(affectedIdsKey); ok {
+		return ids.([]any)
+	}
+	return nil
+}
+
+// execWithAffectedIds 如果开启了 CaptureAffectedIds，在同一个事务中先通过 SELECT ... FOR UPDATE
+// 锁定并记录满足条件的主键，再执行更新或删除，保证记录的主键就是实际变更的行
+func execWithAffectedIds[T any](q *QueryCond[T], opts []OptionFunc, exec func(q *QueryCond[T], opts ...OptionFunc) *gorm.DB) *gorm.DB {
+	option := getOption(opts)
+	if !option.CaptureAffectedIds {
+		return exec(q, opts...)
+	}
+
+	var ids []any
+	resultDb := runInTx(opts, func(tx *gorm.DB) (*gorm.DB, error) {
+		lockDb := buildCondition[T](q, Db(tx))
+		lockDb.Statement.Selects = nil
+		lockedIds, err := pluckPkValues[T](lockDb.Clauses(clause.Locking{Strength: "UPDATE"}))
+		if err != nil {
+			return nil, err
+		}
+		ids = lockedIds
+		execDb := exec(q, withTx(opts, tx)...)
+		return execDb, execDb.Error
+	})
+	resultDb.InstanceSet(affectedIdsKey, ids)
+	return resultDb
+}
+
+// runInTx 在事务中执行 fn，返回 fn 返回的 Db；fn 没有返回 Db 时返回携带错误的新 Db，提交事务失败时同样添加错误
+func runInTx(opts []OptionFunc, fn func(tx *gorm.DB) (*gorm.DB, error)) *gorm.DB {
+	var resultDb *gorm.DB
+	err := getTxDb(opts).Transaction(func(tx *gorm.DB) error {
+		var err error
+		resultDb, err = fn(tx)
+		return err
+	})
+	if resultDb == nil {
+		resultDb = getDb(opts...)
+		resultDb.AddError(err)
+		return resultDb
+	}
+	// 提交事务失败时，需要把错误返回给调用方
+	if err != nil && resultDb.Error == nil {
+		resultDb.AddError(err)
+	}
 	return resultDb
 }
 
+// pluckPkValues 查询主键值，按主键字段类型接收，避免驱动在没有绑定参数时以 []byte 返回
+func pluckPkValues[T any](db *gorm.DB) ([]any, error) {
+	s, err := parseSchema[T]()
+	if err != nil {
+		return nil, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return nil, gorm.ErrPrimaryKeyRequired
+	}
+	pkField := s.PrioritizedPrimaryField
+	values := reflect.New(reflect.SliceOf(pkField.FieldType))
+	if err = db.Pluck(pkField.DBName, values.Interface()).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]any, values.Elem().Len())
+	for i := range ids {
+		ids[i] = values.Elem().Index(i).Interface()
+	}
+	return ids, nil
+}
+
+// getTxDb 获取用于开启事务的 Db，只继承 Db 和 Context 选项，其他选项由事务中执行的操作处理，避免重复设置
+func getTxDb(opts []OptionFunc) *gorm.DB {
+	option := getOption(opts)
+	var txOpts []OptionFunc
+	if option.Db != nil {
+		txOpts = append(txOpts, Db(option.Db))
+	}
+	if option.Context != nil {
+		txOpts = append(txOpts, Context(option.Context))
+	}
+	return getDb(txOpts...)
+}
+
 // SelectById 根据 ID 查询单条记录
 func SelectById[T any](id any, opts ...OptionFunc) (*T, *gorm.DB) {
 	q, _ := NewQuery[T]()
@@ -183,10 +412,66 @@ func SelectById[T any](id any, opts ...OptionFunc) (*T, *gorm.DB) {
 }
 
 // SelectByIds 根据 ID 查询多条记录
+// 使用 OrderByIds 选项时结果按照传入的 ID 顺序排列，使用 CaptureMissingIds 选项时可以通过 GetMissingIds 获取未查询到的 ID
 func SelectByIds[T any](ids any, opts ...OptionFunc) ([]*T, *gorm.DB) {
 	q, _ := NewQuery[T]()
 	q.In(getPkColumnName[T](), ids)
-	return SelectList[T](q, opts...)
+	results, resultDb := SelectList[T](q, opts...)
+	if resultDb.Error != nil {
+		return results, resultDb
+	}
+	return arrangeByIds(ids, results, getOption(opts), resultDb), resultDb
+}
+
+// GetMissingIds 获取 SelectByIds 使用 CaptureMissingIds 选项时未查询到的 ID，按传入顺序排列
+func GetMissingIds(db *gorm.DB) []any {
+	if ids, ok := db.InstanceGet(missingIdsKey); ok {
+		return ids.([]any)
+	}
+	return nil
+}
+
+// arrangeByIds 按照传入的 ID 顺序排列查询结果，并记录未查询到的 ID，重复的 ID 只保留第一次出现的位置
+func arrangeByIds[T any](ids any, entities []*T, option Option, resultDb *gorm.DB) []*T {
+	if !option.OrderByIds && !option.CaptureMissingIds {
+		return entities
+	}
+	idValues := reflect.ValueOf(ids)
+	if idValues.Kind() != reflect.Slice && idValues.Kind() != reflect.Array {
+		return entities
+	}
+
+	// ID 的类型可能与主键字段类型不同，例如 []int 查询 int64 主键，统一转换为字符串比较
+	entityMap := make(map[string]*T, len(entities))
+	for _, entity := range entities {
+		id, _ := getPkValue(entity)
+		entityMap[fmt.Sprint(id)] = entity
+	}
+
+	var results []*T
+	var missingIds []any
+	seen := make(map[string]struct{}, idValues.Len())
+	for i := 0; i < idValues.Len(); i++ {
+		id := idValues.Index(i).Interface()
+		key := fmt.Sprint(id)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		if entity, ok := entityMap[key]; ok {
+			results = append(results, entity)
+		} else {
+			missingIds = append(missingIds, id)
+		}
+	}
+
+	if option.CaptureMissingIds {
+		resultDb.InstanceSet(missingIdsKey, missingIds)
+	}
+	if option.OrderByIds {
+		return results
+	}
+	return entities
 }
 
 // SelectOne 根据条件查询单条记录
@@ -204,6 +489,45 @@ func SelectList[T any](q *QueryCond[T], opts ...OptionFunc) ([]*T, *gorm.DB) {
 	return results, resultDb
 }
 
+// ErrChanOrderBy SelectChan 按主键范围分批查询，不支持指定排序
+var ErrChanOrderBy = errors.New("gplus: SelectChan does not support order by, records are ordered by primary key")
+
+// SelectChan 根据条件按主键顺序分批查询记录，并逐条写入 channel，适合 ETL 等流式处理场景，不需要一次性加载全部记录
+// 查询结束或出错后关闭记录 channel，错误 channel 最多返回一个错误，ctx 取消时停止查询
+// buffer 为记录 channel 的缓冲大小，小于 0 时按 0 处理；分批查询基于主键范围，条件中设置了排序时返回 ErrChanOrderBy
+func SelectChan[T any](ctx context.Context, q *QueryCond[T], buffer int, opts ...OptionFunc) (<-chan *T, <-chan error) {
+	if buffer < 0 {
+		buffer = 0
+	}
+	records := make(chan *T, buffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(records)
+
+		resultDb := buildCondition(q, append(append([]OptionFunc{}, opts...), Context(ctx))...)
+		if _, ok := resultDb.Statement.Clauses["ORDER BY"]; ok {
+			errs <- ErrChanOrderBy
+			return
+		}
+		var results []*T
+		resultDb = resultDb.FindInBatches(&results, defaultBatchSize, func(tx *gorm.DB, batch int) error {
+			for _, result := range results {
+				select {
+				case records <- result:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+		if resultDb.Error != nil {
+			errs <- resultDb.Error
+		}
+	}()
+	return records, errs
+}
+
 // SelectPage 根据条件分页查询记录
 func SelectPage[T any](page *Page[T], q *QueryCond[T], opts ...OptionFunc) (*Page[T], *gorm.DB) {
 	option := getOption(opts)
@@ -244,12 +568,27 @@ func SelectStreamingPage[T any, V Comparable](page *StreamingPage[T, V], q *Quer
 	return page, resultDb
 }
 
+// SelectListWithTotal 根据条件查询多条记录，同时返回满足条件的总数，总数不受 Limit、Offset 影响
+func SelectListWithTotal[T any](q *QueryCond[T], opts ...OptionFunc) ([]*T, int64, *gorm.DB) {
+	total, countDb := SelectCount[T](q, opts...)
+	if countDb.Error != nil {
+		return nil, total, countDb
+	}
+	results, resultDb := SelectList[T](q, opts...)
+	return results, total, resultDb
+}
+
 // SelectCount 根据条件查询记录数量
 func SelectCount[T any](q *QueryCond[T], opts ...OptionFunc) (int64, *gorm.DB) {
 	var count int64
 	resultDb := buildCondition(q, opts...)
 	//fix 查询有设置Select并且数量只有一个且有设置别名,生成sql不对问题
 	resultDb.Statement.Selects = nil
+	// 总数不受 Limit、Offset 影响
+	delete(resultDb.Statement.Clauses, "LIMIT")
+	if option := getOption(opts); option.CountCache != nil {
+		return cachedCount[T](option.CountCache, resultDb), resultDb
+	}
 	resultDb.Count(&count)
 	return count, resultDb
 }
@@ -260,6 +599,44 @@ func Exists[T any](q *QueryCond[T], opts ...OptionFunc) (bool, *gorm.DB) {
 	return count > 0, resultDb
 }
 
+// SubQuery 根据条件构建子查询，可以用于 Exists、NotExists，关联外层表的条件可以继续调用 Where 添加
+func SubQuery[T any](q *QueryCond[T], opts ...OptionFunc) *gorm.DB {
+	return buildCondition(q, opts...)
+}
+
+// SelectOrphans 查询外键在关联表中不存在对应记录的数据，例如：SelectOrphans[MemberCard, Member](&c.MemberID)
+// 外键为 NULL 的记录不会被查询出来，关联表使用软删除时，关联到已删除记录的数据同样会被查询出来
+func SelectOrphans[T any, U any](fkColumn any, opts ...OptionFunc) ([]*T, *gorm.DB) {
+	subQuery, err := orphanSubQuery[T, U](fkColumn, opts)
+	if err != nil {
+		resultDb := getDb(opts...)
+		resultDb.AddError(err)
+		return nil, resultDb
+	}
+	q, _ := NewQuery[T]()
+	q.IsNotNull(fkColumn).NotExists(subQuery)
+	return SelectList[T](q, opts...)
+}
+
+// orphanSubQuery 构建 SELECT 1 FROM U WHERE U.主键 = T.外键 子查询，与事务一样只继承 Db 和 Context 选项
+func orphanSubQuery[T any, U any](fkColumn any, opts []OptionFunc) (*gorm.DB, error) {
+	tSchema, err := parseSchema[T]()
+	if err != nil {
+		return nil, err
+	}
+	uSchema, err := parseSchema[U]()
+	if err != nil {
+		return nil, err
+	}
+	if uSchema.PrioritizedPrimaryField == nil {
+		return nil, gorm.ErrPrimaryKeyRequired
+	}
+
+	pkCol := clause.Column{Table: uSchema.Table, Name: uSchema.PrioritizedPrimaryField.DBName}
+	fkCol := clause.Column{Table: tSchema.Table, Name: getColumnName(fkColumn)}
+	return getTxDb(opts).Model(new(U)).Select("1").Where("? = ?", pkCol, fkCol), nil
+}
+
 // SelectPageGeneric 根据传入的泛型封装分页记录
 // 第一个泛型代表数据库表实体
 // 第二个泛型代表返回记录实体
@@ -279,6 +656,9 @@ func SelectPageGeneric[T any, R any](page *Page[R], q *QueryCond[T], opts ...Opt
 	case map[string]any:
 		var results []R
 		resultDb.Scopes(paginate(page)).Scan(&results)
+		if err := remapResults[T](option.MapKey, any(results).([]map[string]any)); err != nil {
+			resultDb.AddError(err)
+		}
 		page.RecordsMap = results
 	default:
 		var results []*R
@@ -307,6 +687,9 @@ func SelectStreamingPageGeneric[T any, R any, V Comparable](page *StreamingPage[
 	case map[string]any:
 		var results []R
 		resultDb.Scopes(streamingPaginate(page)).Scan(&results)
+		if err := remapResults[T](option.MapKey, any(results).([]map[string]any)); err != nil {
+			resultDb.AddError(err)
+		}
 		page.RecordsMap = results
 	default:
 		var results []*R
@@ -321,8 +704,22 @@ func SelectStreamingPageGeneric[T any, R any, V Comparable](page *StreamingPage[
 // 第二个泛型代表返回记录实体
 func SelectGeneric[T any, R any](q *QueryCond[T], opts ...OptionFunc) (R, *gorm.DB) {
 	var entity R
-	resultDb := buildCondition(q, opts...)
-	return entity, resultDb.Scan(&entity)
+	resultDb := buildCondition(q, opts...).Scan(&entity)
+	if option := getOption(opts); option.MapKey != 0 && resultDb.Error == nil {
+		var err error
+		switch results := any(&entity).(type) {
+		case *[]map[string]any:
+			err = remapResults[T](option.MapKey, *results)
+		case *map[string]any:
+			records := []map[string]any{*results}
+			err = remapResults[T](option.MapKey, records)
+			*results = records[0]
+		}
+		if err != nil {
+			resultDb.AddError(err)
+		}
+	}
+	return entity, resultDb
 }
 
 func Begin(opts ...*sql.TxOptions) *gorm.DB {
@@ -451,18 +848,36 @@ func buildSqlAndArgs[T any](expressions []any, sqlBuilder *strings.Builder, quer
 func getDb(opts ...OptionFunc) *gorm.DB {
 	option := getOption(opts)
 	// Clauses()目的是为了初始化Db，如果db已经被初始化了,会直接返回db
-	var db = globalDb.Clauses()
+	var db = GetDB().Clauses()
 
 	if option.Db != nil {
 		db = option.Db.Clauses()
 	}
 
+	if option.Context != nil {
+		db = db.WithContext(option.Context).Clauses()
+	}
+
 	// 设置需要忽略的字段
 	setOmitIfNeed(option, db)
 
 	// 设置选择的字段
 	setSelectIfNeed(option, db)
 
+	// 设置时间点查询
+	if option.asOf != nil {
+		db.Clauses(asOf{time: *option.asOf})
+	}
+
+	// 设置索引提示
+	if len(option.indexHints) > 0 {
+		db.Clauses(option.indexHints)
+	}
+
+	for _, err := range option.errors {
+		db.AddError(err)
+	}
+
 	return db
 }
 
@@ -474,6 +889,11 @@ func getOption(opts []OptionFunc) Option {
 	return config
 }
 
+// withTx 复制选项并在最后追加事务 Db，保证在事务中执行
+func withTx(opts []OptionFunc, tx *gorm.DB) []OptionFunc {
+	return append(append([]OptionFunc{}, opts...), Db(tx))
+}
+
 func setSelectIfNeed(option Option, db *gorm.DB) {
 	if len(option.Selects) > 0 {
 		var columnNames []string
@@ -520,3 +940,12 @@ func getPkColumnName[T any]() string {
 	}
 	return columnName
 }
+
+// getPkValue 获取实体的主键值，以及主键是否为零值
+func getPkValue[T any](entity *T) (any, bool) {
+	s, err := parseSchema[T]()
+	if err != nil || s.PrioritizedPrimaryField == nil {
+		return nil, true
+	}
+	return s.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(entity).Elem())
+}
@@ -0,0 +1,137 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"fmt"
+	"github.com/acmestack/gorm-plus/constants"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sync"
+)
+
+// 缓存实体注册的索引名，key为实体类型，value为索引名集合
+var indexNameCache sync.Map
+
+var indexRegisterMu sync.Mutex
+
+type indexHint struct {
+	raw   string
+	kind  string
+	names []string
+}
+
+// indexHints 索引提示，追加在 FROM 表名之后
+type indexHints []indexHint
+
+func (hints indexHints) ModifyStatement(stmt *gorm.Statement) {
+	fromClause := stmt.Clauses["FROM"]
+	fromClause.AfterExpression = hints
+	stmt.Clauses["FROM"] = fromClause
+}
+
+func (hints indexHints) Build(builder clause.Builder) {
+	for i, hint := range hints {
+		if i > 0 {
+			builder.WriteByte(' ')
+		}
+		if hint.raw != "" {
+			builder.WriteString(hint.raw)
+			continue
+		}
+		builder.WriteString(hint.kind)
+		builder.WriteString(" INDEX ")
+		builder.WriteString(constants.LeftBracket)
+		for j, name := range hint.names {
+			if j > 0 {
+				builder.WriteString(constants.Comma)
+			}
+			builder.WriteQuoted(name)
+		}
+		builder.WriteString(constants.RightBracket)
+	}
+}
+
+// RegisterIndex 注册实体的索引名，供 ForceIndex、UseIndex、IgnoreIndex 校验使用
+// 通过 gorm 标签声明的索引会自动识别，不需要注册
+func RegisterIndex[T any](indexNames ...string) {
+	indexRegisterMu.Lock()
+	defer indexRegisterMu.Unlock()
+	// 复制后再替换，避免并发读取时修改同一个 map
+	names := make(map[string]struct{})
+	for name := range getIndexNames[T]() {
+		names[name] = struct{}{}
+	}
+	for _, name := range indexNames {
+		names[name] = struct{}{}
+	}
+	indexNameCache.Store(reflect.TypeOf((*T)(nil)).Elem().String(), names)
+}
+
+// WithIndexHint 使用原始的索引提示，例如 "FORCE INDEX(idx_user_created)"
+// Tips: 内容会直接拼接到 SQL 中，不能使用外部传入的值
+func WithIndexHint(hint string) OptionFunc {
+	return func(o *Option) {
+		o.indexHints = append(o.indexHints, indexHint{raw: hint})
+	}
+}
+
+// ForceIndex 强制使用索引 FORCE INDEX，索引名需要是实体声明或注册过的索引
+func ForceIndex[T any](indexNames ...string) OptionFunc {
+	return typedIndexHint[T]("FORCE", indexNames)
+}
+
+// UseIndex 建议使用索引 USE INDEX，索引名需要是实体声明或注册过的索引
+func UseIndex[T any](indexNames ...string) OptionFunc {
+	return typedIndexHint[T]("USE", indexNames)
+}
+
+// IgnoreIndex 忽略索引 IGNORE INDEX，索引名需要是实体声明或注册过的索引
+func IgnoreIndex[T any](indexNames ...string) OptionFunc {
+	return typedIndexHint[T]("IGNORE", indexNames)
+}
+
+func typedIndexHint[T any](kind string, indexNames []string) OptionFunc {
+	return func(o *Option) {
+		names := getIndexNames[T]()
+		for _, name := range indexNames {
+			if _, ok := names[name]; !ok {
+				o.errors = append(o.errors, fmt.Errorf("gplus: index %q is not registered for %s", name, reflect.TypeOf((*T)(nil)).Elem().String()))
+				return
+			}
+		}
+		o.indexHints = append(o.indexHints, indexHint{kind: kind, names: indexNames})
+	}
+}
+
+// getIndexNames 获取实体的索引名集合，首次获取时解析 gorm 标签中声明的索引
+func getIndexNames[T any]() map[string]struct{} {
+	modelTypeStr := reflect.TypeOf((*T)(nil)).Elem().String()
+	if names, ok := indexNameCache.Load(modelTypeStr); ok {
+		return names.(map[string]struct{})
+	}
+	names := make(map[string]struct{})
+	if s, err := parseSchema[T](); err == nil {
+		for name := range s.ParseIndexes() {
+			names[name] = struct{}{}
+		}
+	}
+	actual, _ := indexNameCache.LoadOrStore(modelTypeStr, names)
+	return actual.(map[string]struct{})
+}
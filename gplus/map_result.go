@@ -0,0 +1,135 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MapKeyStyle map 结果的键名格式
+type MapKeyStyle int
+
+const (
+	// MapKeyColumn 数据库字段名，例如：created_at
+	MapKeyColumn MapKeyStyle = iota + 1
+	// MapKeyField 实体字段名，例如：CreatedAt
+	MapKeyField
+	// MapKeyJSON 实体字段的 json 标签名，没有标签时使用小驼峰，例如：createdAt
+	MapKeyJSON
+)
+
+// remapResults 转换 map 结果的键名和值，[]byte 转为 string，decimal 等 driver.Valuer 转为 Value() 返回的值
+func remapResults[T any](style MapKeyStyle, results []map[string]any) error {
+	if style == 0 {
+		return nil
+	}
+	keys, err := getMapKeys[T](style)
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		remapped := make(map[string]any, len(result))
+		for column, value := range result {
+			key, ok := keys[column]
+			if !ok {
+				key = columnToKey(style, column)
+			}
+			remapped[key] = convertMapValue(value)
+		}
+		results[i] = remapped
+	}
+	return nil
+}
+
+// getMapKeys 获取数据库字段名和键名的对应关系
+func getMapKeys[T any](style MapKeyStyle) (map[string]string, error) {
+	s, err := parseSchema[T]()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(s.DBNames))
+	for dbName, field := range s.FieldsByDBName {
+		switch style {
+		case MapKeyField:
+			keys[dbName] = field.Name
+		case MapKeyJSON:
+			name, _, _ := strings.Cut(field.StructField.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				name = lowerFirst(field.Name)
+			}
+			keys[dbName] = name
+		default:
+			keys[dbName] = dbName
+		}
+	}
+	return keys, nil
+}
+
+// columnToKey 不属于实体的字段（例如聚合函数的别名）按下划线转换为驼峰
+func columnToKey(style MapKeyStyle, column string) string {
+	if style == MapKeyColumn {
+		return column
+	}
+	var builder strings.Builder
+	for _, part := range strings.Split(column, "_") {
+		if part == "" {
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(part)
+		builder.WriteRune(unicode.ToUpper(r))
+		builder.WriteString(part[size:])
+	}
+	if style == MapKeyJSON {
+		return lowerFirst(builder.String())
+	}
+	return builder.String()
+}
+
+// lowerFirst 转换为小驼峰，开头连续的大写字母同样转为小写，例如：ID 转为 id，URLPath 转为 urlPath
+func lowerFirst(s string) string {
+	runes := []rune(s)
+	for i := 0; i < len(runes) && unicode.IsUpper(runes[i]); i++ {
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+	return string(runes)
+}
+
+func convertMapValue(value any) any {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case sql.RawBytes:
+		return string(v)
+	case driver.Valuer:
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil
+		}
+		if converted, err := v.Value(); err == nil {
+			return convertMapValue(converted)
+		}
+	}
+	return value
+}
@@ -17,13 +17,27 @@
 
 package gplus
 
-import "gorm.io/gorm"
+import (
+	"context"
+	"gorm.io/gorm"
+	"time"
+)
 
 type Option struct {
-	Db          *gorm.DB
-	Selects     []any
-	Omits       []any
-	IgnoreTotal bool
+	Db                 *gorm.DB
+	Context            context.Context
+	Selects            []any
+	Omits              []any
+	IgnoreTotal        bool
+	CaptureAffectedIds bool
+	CaptureMissingIds  bool
+	OrderByIds         bool
+	TrackChanges       bool
+	CountCache         *CountCache
+	MapKey             MapKeyStyle
+	asOf               *time.Time
+	indexHints         indexHints
+	errors             []error
 }
 
 type OptionFunc func(*Option)
@@ -35,10 +49,17 @@ func Db(db *gorm.DB) OptionFunc {
 	}
 }
 
+// Context 使用传入的 Context 执行 SQL
+func Context(ctx context.Context) OptionFunc {
+	return func(o *Option) {
+		o.Context = ctx
+	}
+}
+
 // Session 创建回话
 func Session(session *gorm.Session) OptionFunc {
 	return func(o *Option) {
-		o.Db = globalDb.Session(session)
+		o.Db = GetDB().Session(session)
 	}
 }
 
@@ -62,3 +83,54 @@ func IgnoreTotal() OptionFunc {
 		o.IgnoreTotal = true
 	}
 }
+
+// CaptureAffectedIds Update、Delete 以及 UpdateById、DeleteById 等基于它们的方法执行时记录受影响的主键，通过 GetAffectedIds 获取
+// Tips: 会在事务中先执行 SELECT ... FOR UPDATE 锁定记录，有额外的查询开销
+func CaptureAffectedIds() OptionFunc {
+	return func(o *Option) {
+		o.CaptureAffectedIds = true
+	}
+}
+
+// CaptureMissingIds SelectByIds 时记录未查询到的 ID，通过 GetMissingIds 获取
+func CaptureMissingIds() OptionFunc {
+	return func(o *Option) {
+		o.CaptureMissingIds = true
+	}
+}
+
+// OrderByIds SelectByIds 时按照传入的 ID 顺序返回结果
+func OrderByIds() OptionFunc {
+	return func(o *Option) {
+		o.OrderByIds = true
+	}
+}
+
+// WithAsOf 查询指定时间点的记录，支持系统版本表 FOR SYSTEM_TIME AS OF（MariaDB、SQL Server）
+// 其他数据库需要通过 RegisterHistoryTable 注册历史表，仅用于查询
+func WithAsOf(t time.Time) OptionFunc {
+	return func(o *Option) {
+		o.asOf = &t
+	}
+}
+
+// WithCountCache SelectCount、SelectPage 等查询总数时使用缓存，缓存需要先通过 db.Use 注册
+func WithCountCache(cache *CountCache) OptionFunc {
+	return func(o *Option) {
+		o.CountCache = cache
+	}
+}
+
+// MapKey 返回 map 结果时转换键名格式，同时将 []byte 等值转换为可以直接序列化的值
+func MapKey(style MapKeyStyle) OptionFunc {
+	return func(o *Option) {
+		o.MapKey = style
+	}
+}
+
+// TrackChanges UpdateById 时先查询当前记录，只更新发生变化的字段，通过 GetChanges 获取变更内容
+func TrackChanges() OptionFunc {
+	return func(o *Option) {
+		o.TrackChanges = true
+	}
+}
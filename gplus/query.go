@@ -20,6 +20,7 @@ package gplus
 import (
 	"fmt"
 	"github.com/acmestack/gorm-plus/constants"
+	"gorm.io/gorm"
 	"reflect"
 	"strings"
 )
@@ -208,6 +209,18 @@ func (q *QueryCond[T]) NotBetween(column any, start, end any) *QueryCond[T] {
 	return q
 }
 
+// Exists EXISTS (子查询)，子查询可以通过 SubQuery 构建
+func (q *QueryCond[T]) Exists(subQuery *gorm.DB) *QueryCond[T] {
+	q.addExpression(q.buildSubQuerySegment(constants.Exists, subQuery)...)
+	return q
+}
+
+// NotExists NOT EXISTS (子查询)，子查询可以通过 SubQuery 构建
+func (q *QueryCond[T]) NotExists(subQuery *gorm.DB) *QueryCond[T] {
+	q.addExpression(q.buildSubQuerySegment(constants.Not+" "+constants.Exists, subQuery)...)
+	return q
+}
+
 // Distinct 去除重复字段值
 func (q *QueryCond[T]) Distinct(columns ...any) *QueryCond[T] {
 	for _, v := range columns {
@@ -312,6 +325,18 @@ func (q *QueryCond[T]) Omit(columns ...any) *QueryCond[T] {
 	return q
 }
 
+// Limit 限制查询的记录数量
+func (q *QueryCond[T]) Limit(limit int) *QueryCond[T] {
+	q.limit = &limit
+	return q
+}
+
+// Offset 跳过的记录数量
+func (q *QueryCond[T]) Offset(offset int) *QueryCond[T] {
+	q.offset = offset
+	return q
+}
+
 // Set 设置更新的字段
 func (q *QueryCond[T]) Set(column any, val any) *QueryCond[T] {
 	columnName := getColumnName(column)
@@ -738,6 +763,15 @@ func (q *QueryCond[T]) buildSqlSegment(column any, condType string, values ...an
 	return sqlSegments
 }
 
+func (q *QueryCond[T]) buildSubQuerySegment(keyword string, subQuery *gorm.DB) []SqlSegment {
+	return []SqlSegment{
+		&sqlKeyword{keyword: keyword},
+		&sqlKeyword{keyword: constants.LeftBracket},
+		&columnValue{value: subQuery},
+		&sqlKeyword{keyword: constants.RightBracket},
+	}
+}
+
 func (q *QueryCond[T]) buildOrder(orderType string, columns ...string) {
 	for _, v := range columns {
 		if q.orderBuilder.Len() > 0 {
@@ -0,0 +1,111 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"fmt"
+	"github.com/acmestack/gorm-plus/constants"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// QueryMap 根据 Map 构建查询条件，key 为字段名，可以在字段名后追加操作符，例如 "age >=": 18
+// 未指定操作符时默认为等于，支持的操作符：=、!=、<>、>、>=、<、<=、LIKE、NOT LIKE、IN、NOT IN、BETWEEN、NOT BETWEEN
+// 字段名必须是实体中存在的列名，避免将外部传入的 key 直接拼接到 SQL 中
+func QueryMap[T any](conditions map[string]any) (*QueryCond[T], error) {
+	q, _ := NewQuery[T]()
+	columnTypeMap := getColumnTypeMap[T]()
+
+	// map 遍历是无序的，排序后保证生成的 SQL 稳定
+	keys := make([]string, 0, len(conditions))
+	for key := range conditions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		column, op := parseMapKey(key)
+		if _, ok := columnTypeMap[column]; !ok {
+			return nil, fmt.Errorf("gplus: unknown column %q in query map", column)
+		}
+		if err := addMapCondition(q, column, op, conditions[key]); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// parseMapKey 解析 key 中的字段名和操作符
+func parseMapKey(key string) (string, string) {
+	fields := strings.Fields(key)
+	if len(fields) == 0 {
+		return "", constants.Eq
+	}
+	if len(fields) == 1 {
+		return fields[0], constants.Eq
+	}
+	return fields[0], strings.ToUpper(strings.Join(fields[1:], " "))
+}
+
+func addMapCondition[T any](q *QueryCond[T], column string, op string, value any) error {
+	switch op {
+	case constants.Eq:
+		if value == nil {
+			q.IsNull(column)
+		} else {
+			q.Eq(column, value)
+		}
+	case "!=", constants.Ne:
+		if value == nil {
+			q.IsNotNull(column)
+		} else {
+			q.Ne(column, value)
+		}
+	case constants.Gt:
+		q.Gt(column, value)
+	case constants.Ge:
+		q.Ge(column, value)
+	case constants.Lt:
+		q.Lt(column, value)
+	case constants.Le:
+		q.Le(column, value)
+	case constants.Like:
+		q.Like(column, value)
+	case constants.Not + " " + constants.Like:
+		q.NotLike(column, value)
+	case constants.In:
+		q.In(column, value)
+	case constants.Not + " " + constants.In:
+		q.NotIn(column, value)
+	case constants.Between, constants.Not + " " + constants.Between:
+		valueOf := reflect.ValueOf(value)
+		if (valueOf.Kind() != reflect.Slice && valueOf.Kind() != reflect.Array) || valueOf.Len() != 2 {
+			return fmt.Errorf("gplus: %s of column %q requires two values", op, column)
+		}
+		start, end := valueOf.Index(0).Interface(), valueOf.Index(1).Interface()
+		if op == constants.Between {
+			q.Between(column, start, end)
+		} else {
+			q.NotBetween(column, start, end)
+		}
+	default:
+		return fmt.Errorf("gplus: unsupported operator %q of column %q in query map", op, column)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const statsStartTimeKey = "gplus:stats_start_time"
+
+// 默认相同 SQL 执行次数达到 3 次时认为可能存在 N+1 查询
+const defaultRepeatThreshold = 3
+
+// 将 IN (?,?,?) 这类占位符归一化，避免参数个数不同导致相同的查询被识别成不同的 SQL
+var placeholderListRegexp = regexp.MustCompile(`\(\s*\?(\s*,\s*\?)*\s*\)`)
+
+type statsContextKey struct{}
+
+// StatsRecord 单条 SQL 的执行记录
+type StatsRecord struct {
+	SQL          string        // 归一化后的 SQL，不包含参数值
+	Table        string        // 表名
+	Duration     time.Duration // 执行耗时
+	RowsAffected int64         // 影响行数
+	Error        error         // 执行错误
+}
+
+// StatsSummary 统计汇总
+type StatsSummary struct {
+	Count         int            // SQL 执行次数
+	TotalDuration time.Duration  // 总耗时
+	Slowest       *StatsRecord   // 耗时最长的 SQL
+	Repeated      map[string]int // 执行次数达到阈值的 SQL 及其次数，可能存在 N+1 查询
+	Tables        map[string]int // 每张表的 SQL 执行次数
+	Errors        int            // 执行出错的次数
+	Records       []StatsRecord  // 所有执行记录
+}
+
+// StatsCollector 请求级别的 SQL 统计收集器，通过 WithStatsCollector 绑定到 Context 上
+type StatsCollector struct {
+	RepeatThreshold int
+
+	mu      sync.Mutex
+	records []StatsRecord
+}
+
+// NewStatsCollector 创建统计收集器
+func NewStatsCollector() *StatsCollector {
+	return &StatsCollector{RepeatThreshold: defaultRepeatThreshold}
+}
+
+// WithStatsCollector 将统计收集器绑定到 Context，使用该 Context 执行的 SQL 都会被记录
+func WithStatsCollector(ctx context.Context, collector *StatsCollector) context.Context {
+	return context.WithValue(ctx, statsContextKey{}, collector)
+}
+
+// GetStatsCollector 获取 Context 绑定的统计收集器
+func GetStatsCollector(ctx context.Context) *StatsCollector {
+	if ctx == nil {
+		return nil
+	}
+	collector, _ := ctx.Value(statsContextKey{}).(*StatsCollector)
+	return collector
+}
+
+func (c *StatsCollector) record(record StatsRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = append(c.records, record)
+}
+
+// Reset 清空已经收集的记录
+func (c *StatsCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.records = nil
+}
+
+// Summary 汇总已经收集的记录
+func (c *StatsCollector) Summary() StatsSummary {
+	c.mu.Lock()
+	records := append([]StatsRecord{}, c.records...)
+	c.mu.Unlock()
+
+	summary := StatsSummary{
+		Repeated: make(map[string]int),
+		Tables:   make(map[string]int),
+		Records:  records,
+	}
+	sqlCountMap := make(map[string]int)
+	for i, record := range records {
+		summary.Count++
+		summary.TotalDuration += record.Duration
+		if summary.Slowest == nil || record.Duration > summary.Slowest.Duration {
+			summary.Slowest = &records[i]
+		}
+		if record.Error != nil {
+			summary.Errors++
+		}
+		summary.Tables[record.Table]++
+		sqlCountMap[record.SQL]++
+	}
+
+	threshold := c.RepeatThreshold
+	if threshold <= 0 {
+		threshold = defaultRepeatThreshold
+	}
+	for sql, count := range sqlCountMap {
+		if count >= threshold {
+			summary.Repeated[sql] = count
+		}
+	}
+	return summary
+}
+
+// String 输出统计汇总信息
+func (s StatsSummary) String() string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("gplus stats: %d queries, total %v, %d errors\n", s.Count, s.TotalDuration, s.Errors))
+	if s.Slowest != nil {
+		builder.WriteString(fmt.Sprintf("slowest: %v %s\n", s.Slowest.Duration, s.Slowest.SQL))
+	}
+
+	var tables []string
+	for table := range s.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		builder.WriteString(fmt.Sprintf("table %s: %d queries\n", table, s.Tables[table]))
+	}
+
+	var repeatedSqls []string
+	for sql := range s.Repeated {
+		repeatedSqls = append(repeatedSqls, sql)
+	}
+	sort.Strings(repeatedSqls)
+	for _, sql := range repeatedSqls {
+		builder.WriteString(fmt.Sprintf("possible N+1: %d times %s\n", s.Repeated[sql], sql))
+	}
+	return builder.String()
+}
+
+// StatsPlugin 统计 SQL 执行情况的 gorm 插件，通过 db.Use(&gplus.StatsPlugin{}) 注册
+// 只有 Context 绑定了 StatsCollector 的 SQL 才会被记录
+type StatsPlugin struct{}
+
+func (p *StatsPlugin) Name() string {
+	return "gplus:stats"
+}
+
+func (p *StatsPlugin) Initialize(db *gorm.DB) error {
+	return registerAroundCallbacks(db, "gplus:stats", statsBefore, statsAfter)
+}
+
+func statsBefore(db *gorm.DB) {
+	if GetStatsCollector(db.Statement.Context) != nil {
+		db.InstanceSet(statsStartTimeKey, time.Now())
+	}
+}
+
+func statsAfter(db *gorm.DB) {
+	collector := GetStatsCollector(db.Statement.Context)
+	if collector == nil {
+		return
+	}
+	var duration time.Duration
+	if startTime, ok := db.InstanceGet(statsStartTimeKey); ok {
+		duration = time.Since(startTime.(time.Time))
+	}
+	collector.record(StatsRecord{
+		SQL:          normalizeSql(db.Statement.SQL.String()),
+		Table:        db.Statement.Table,
+		Duration:     duration,
+		RowsAffected: db.RowsAffected,
+		Error:        db.Error,
+	})
+}
+
+// normalizeSql 合并多余的空白并归一化占位符列表
+func normalizeSql(sql string) string {
+	sql = strings.Join(strings.Fields(sql), " ")
+	return placeholderListRegexp.ReplaceAllString(sql, "(?)")
+}
@@ -0,0 +1,106 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// 缓存实体注册的历史表，key为实体表名
+var historyTableCache sync.Map
+
+type historyTable struct {
+	table       string
+	startColumn string
+	endColumn   string
+}
+
+// RegisterHistoryTable 为不支持系统版本表的数据库注册历史表，WithAsOf 查询时会合并当前表和历史表
+// 历史表需要和当前表字段一致，startColumn、endColumn 为记录的有效期字段，有效期为 [startColumn, endColumn)
+func RegisterHistoryTable[T any](table string, startColumn any, endColumn any) {
+	s, err := parseSchema[T]()
+	if err != nil {
+		panic(fmt.Sprintf("gplus: parse %s failed: %v", reflect.TypeOf((*T)(nil)).Elem().String(), err))
+	}
+	historyTableCache.Store(s.Table, historyTable{
+		table:       table,
+		startColumn: getColumnName(startColumn),
+		endColumn:   getColumnName(endColumn),
+	})
+}
+
+// asOf 时间点查询，替换 FROM 子句中的表
+type asOf struct {
+	time time.Time
+}
+
+func (a asOf) ModifyStatement(stmt *gorm.Statement) {
+	fromClause := stmt.Clauses["FROM"]
+	fromClause.Name = "FROM"
+	fromClause.Expression = a
+	stmt.Clauses["FROM"] = fromClause
+}
+
+func (a asOf) Build(builder clause.Builder) {
+	stmt, ok := builder.(*gorm.Statement)
+	if !ok {
+		return
+	}
+
+	// 注册了历史表，使用 UNION 合并当前表和历史表中在该时间点有效的记录
+	if value, ok := historyTableCache.Load(stmt.Table); ok {
+		history := value.(historyTable)
+		builder.WriteString("(SELECT * FROM ")
+		builder.WriteQuoted(stmt.Table)
+		builder.WriteString(" WHERE ")
+		builder.WriteQuoted(history.startColumn)
+		builder.WriteString(" <= ")
+		builder.AddVar(builder, a.time)
+		builder.WriteString(" UNION ALL SELECT * FROM ")
+		builder.WriteQuoted(history.table)
+		builder.WriteString(" WHERE ")
+		builder.WriteQuoted(history.startColumn)
+		builder.WriteString(" <= ")
+		builder.AddVar(builder, a.time)
+		builder.WriteString(" AND ")
+		builder.WriteQuoted(history.endColumn)
+		builder.WriteString(" > ")
+		builder.AddVar(builder, a.time)
+		builder.WriteString(") AS ")
+		builder.WriteQuoted(stmt.Table)
+		return
+	}
+
+	builder.WriteQuoted(clause.Table{Name: clause.CurrentTable})
+	switch stmt.Dialector.Name() {
+	case "mysql":
+		// MariaDB 系统版本表
+		builder.WriteString(" FOR SYSTEM_TIME AS OF TIMESTAMP ")
+	case "sqlserver":
+		builder.WriteString(" FOR SYSTEM_TIME AS OF ")
+	default:
+		stmt.AddError(fmt.Errorf("gplus: %s does not support FOR SYSTEM_TIME AS OF, register a history table for %s", stmt.Dialector.Name(), stmt.Table))
+		return
+	}
+	builder.AddVar(builder, a.time)
+}
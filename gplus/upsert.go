@@ -0,0 +1,165 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+	"reflect"
+	"strings"
+)
+
+const upsertSavePoint = "gplus_upsert_alive"
+
+var deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+
+// UpsertAlive 根据唯一字段插入或更新记录，适用于"未删除的记录中唯一"的软删除场景
+// 不存在记录时插入；存在未删除的记录时更新非零值字段；存在已软删除的记录时恢复该记录并更新非零值字段
+// 整个过程在事务中执行，并发插入导致唯一键冲突时会重新查询一次
+func UpsertAlive[T any](entity *T, uniqueColumns []any, opts ...OptionFunc) *gorm.DB {
+	s, err := parseSchema[T]()
+	if err != nil {
+		resultDb := getDb(opts...)
+		resultDb.AddError(err)
+		return resultDb
+	}
+
+	return runInTx(opts, func(tx *gorm.DB) (*gorm.DB, error) {
+		txOpts := withTx(opts, tx.Unscoped())
+		var retried bool
+		for {
+			resultDb, duplicated := upsertAliveOnce(tx, s, entity, uniqueColumns, txOpts)
+			// 其他事务并发插入了相同的记录，回滚到保存点后重新查询
+			if duplicated && !retried {
+				retried = true
+				continue
+			}
+			return resultDb, resultDb.Error
+		}
+	})
+}
+
+func upsertAliveOnce[T any](tx *gorm.DB, s *schema.Schema, entity *T, uniqueColumns []any, txOpts []OptionFunc) (*gorm.DB, bool) {
+	ctx := tx.Statement.Context
+	entityValue := reflect.ValueOf(entity).Elem()
+
+	var deletedAtField *schema.Field
+	for _, field := range s.Fields {
+		if field.FieldType == deletedAtType {
+			deletedAtField = field
+			break
+		}
+	}
+
+	// 优先查询未删除的记录，唯一索引只约束未删除记录时可能同时存在多条已删除的记录
+	existing, findDb := takeUnique[T](tx, s, entityValue, uniqueColumns)
+	if errors.Is(findDb.Error, gorm.ErrRecordNotFound) && deletedAtField != nil {
+		// 存在多条已删除的记录时恢复最近删除的一条
+		existing, findDb = takeUnique[T](tx.Unscoped().Clauses(clause.OrderBy{Columns: []clause.OrderByColumn{
+			{Column: clause.Column{Name: deletedAtField.DBName}, Desc: true},
+			{Column: clause.Column{Table: clause.CurrentTable, Name: clause.PrimaryKey}, Desc: true},
+		}}), s, entityValue, uniqueColumns)
+	}
+	if errors.Is(findDb.Error, gorm.ErrRecordNotFound) {
+		if err := tx.SavePoint(upsertSavePoint).Error; err != nil {
+			return tx, false
+		}
+		createDb := Insert[T](entity, txOpts...)
+		if isDuplicateKeyError(createDb.Error) {
+			if err := tx.RollbackTo(upsertSavePoint).Error; err != nil {
+				return tx, false
+			}
+			return createDb, true
+		}
+		return createDb, false
+	}
+	if findDb.Error != nil {
+		return findDb, false
+	}
+
+	existingValue := reflect.ValueOf(existing).Elem()
+	if pkField := s.PrioritizedPrimaryField; pkField != nil {
+		pkValue, _ := pkField.ValueOf(ctx, existingValue)
+		if err := pkField.Set(ctx, entityValue, pkValue); err != nil {
+			tx.AddError(err)
+			return tx, false
+		}
+	}
+
+	updateDb := UpdateById[T](entity, txOpts...)
+	if updateDb.Error != nil {
+		return updateDb, false
+	}
+
+	// 恢复已软删除的记录
+	if deletedAtField != nil {
+		if deletedAt, isZero := deletedAtField.ValueOf(ctx, existingValue); !isZero && deletedAt.(gorm.DeletedAt).Valid {
+			reviveDb := getDb(txOpts...).Model(entity).UpdateColumn(deletedAtField.DBName, nil)
+			if reviveDb.Error != nil {
+				return reviveDb, false
+			}
+			updateDb.RowsAffected = reviveDb.RowsAffected
+		}
+	}
+	return updateDb, false
+}
+
+// takeUnique 根据唯一字段加锁查询一条记录
+func takeUnique[T any](db *gorm.DB, s *schema.Schema, entityValue reflect.Value, uniqueColumns []any) (*T, *gorm.DB) {
+	findDb := db.Model(new(T))
+	for _, column := range uniqueColumns {
+		columnName := getColumnName(column)
+		field := s.LookUpField(columnName)
+		if field == nil {
+			findDb.AddError(fmt.Errorf("gplus: unknown column %q of %s", columnName, s.Name))
+			return nil, findDb
+		}
+		value, _ := field.ValueOf(db.Statement.Context, entityValue)
+		findDb.Where(clause.Eq{Column: clause.Column{Name: field.DBName}, Value: value})
+	}
+	// SQLite 不支持 FOR UPDATE
+	if db.Dialector.Name() != "sqlite" {
+		findDb.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	existing := new(T)
+	findDb.Take(existing)
+	return existing, findDb
+}
+
+// isDuplicateKeyError 根据不同数据库的错误信息判断是否是唯一键冲突
+func isDuplicateKeyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	message := err.Error()
+	for _, keyword := range []string{
+		"Error 1062",               // MySQL
+		"SQLSTATE 23505",           // PostgreSQL
+		"UNIQUE constraint failed", // SQLite
+		"Error 2627", "Error 2601", // SQL Server
+	} {
+		if strings.Contains(message, keyword) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package gplus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+// WatchdogConfig 全局 Db 健康检查配置
+type WatchdogConfig struct {
+	// Interval 检查间隔，默认 30 秒
+	Interval time.Duration
+	// Timeout 单次检查超时时间，默认 5 秒
+	Timeout time.Duration
+	// Check 健康检查方法，默认 Ping 数据库。凭证轮换时已建立的连接仍然可用，可以自定义检查逻辑
+	Check func(ctx context.Context, db *gorm.DB) error
+	// Rebuild 检查失败时重新创建 Db，例如读取新的凭证或切换到新的主库，必填
+	// 旧 Db 通过 db.Use 注册的插件（例如 StatsPlugin、CircuitBreakerPlugin）会注册到新 Db 上，插件状态继续共享；
+	// Rebuild 中已经注册过的同名插件不会重复注册
+	Rebuild func(ctx context.Context) (*gorm.DB, error)
+	// OnError 检查或重建失败时回调
+	OnError func(err error)
+	// OnReplace 替换全局 Db 后回调
+	OnReplace func(old, new *gorm.DB)
+	// CloseOldAfter 大于 0 时，替换全局 Db 后等待该时间再关闭旧 Db 的连接池，默认不关闭
+	// 替换前通过 GetDB 获取的 Db、未结束的事务以及 SelectChan 等仍然会使用旧 Db，需要保证它们在该时间内执行完成，
+	// 调用方不要长期持有 GetDB 返回的 Db
+	CloseOldAfter time.Duration
+}
+
+// Watchdog 运行中的全局 Db 健康检查
+type Watchdog struct {
+	done chan struct{}
+}
+
+// Done 返回的 channel 在检查协程退出后关闭
+func (w *Watchdog) Done() <-chan struct{} {
+	return w.done
+}
+
+// Wait 等待检查协程退出，ctx 结束时正在执行的检查会执行完成，但不会再替换全局 Db
+func (w *Watchdog) Wait() {
+	<-w.done
+}
+
+// StartWatchdog 定期检查全局 Db，检查失败时重建并通过 ReplaceDB 替换，ctx 结束时停止检查
+func StartWatchdog(ctx context.Context, config WatchdogConfig) (*Watchdog, error) {
+	if config.Rebuild == nil {
+		return nil, errors.New("gplus: watchdog rebuild func is required")
+	}
+	if config.Interval <= 0 {
+		config.Interval = 30 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.Check == nil {
+		config.Check = pingDb
+	}
+	if config.OnError == nil {
+		config.OnError = func(error) {}
+	}
+	if config.OnReplace == nil {
+		config.OnReplace = func(old, new *gorm.DB) {}
+	}
+
+	watchdog := &Watchdog{done: make(chan struct{})}
+	go func() {
+		defer close(watchdog.done)
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := checkAndRebuild(ctx, config); err != nil && ctx.Err() == nil {
+					config.OnError(err)
+				}
+			}
+		}
+	}()
+	return watchdog, nil
+}
+
+func checkAndRebuild(ctx context.Context, config WatchdogConfig) error {
+	checkCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	current := GetDB()
+	checkErr := config.Check(checkCtx, current)
+	if checkErr == nil {
+		return nil
+	}
+	db, err := config.Rebuild(ctx)
+	if err != nil {
+		return fmt.Errorf("gplus: watchdog rebuild db after check error %v: %w", checkErr, err)
+	}
+	if current == db {
+		return checkErr
+	}
+	if current != nil {
+		if err = usePlugins(current, db); err != nil {
+			closeDb(db)
+			return fmt.Errorf("gplus: watchdog register plugins on rebuilt db: %w", err)
+		}
+	}
+	// 重建期间 ctx 已经结束，不再替换全局 Db，避免停止后仍然修改全局 Db
+	if err = ctx.Err(); err != nil {
+		closeDb(db)
+		return err
+	}
+	old := ReplaceDB(db)
+	if old != nil && old != db {
+		config.OnReplace(old, db)
+		if config.CloseOldAfter > 0 {
+			time.AfterFunc(config.CloseOldAfter, func() {
+				closeDb(old)
+			})
+		}
+	}
+	return checkErr
+}
+
+// usePlugins 将旧 Db 注册的插件注册到新 Db 上，新 Db 已经注册的同名插件跳过
+func usePlugins(old, new *gorm.DB) error {
+	for name, plugin := range old.Config.Plugins {
+		if _, ok := new.Config.Plugins[name]; ok {
+			continue
+		}
+		if err := new.Use(plugin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pingDb(ctx context.Context, db *gorm.DB) error {
+	if db == nil {
+		return errors.New("gplus: global db is not initialized")
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDb.PingContext(ctx)
+}
+
+// closeDb 关闭 Db 的连接池，已经开始执行的查询会等待执行完成
+func closeDb(db *gorm.DB) {
+	if sqlDb, err := db.DB(); err == nil {
+		sqlDb.Close()
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package gplustest 提供测试数据的初始化能力，方便编写基于 gplus 的集成测试
+package gplustest
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+	"sync"
+	"testing"
+)
+
+// 缓存实体解析后的 schema
+var schemaCache sync.Map
+
+// Fixture 某个实体的测试数据
+type Fixture interface {
+	// model 返回实体的零值指针，用于解析表结构
+	model() any
+	// load 插入测试数据
+	load(db *gorm.DB) error
+}
+
+type rows[T any] struct {
+	records []*T
+}
+
+func (r rows[T]) model() any {
+	return new(T)
+}
+
+func (r rows[T]) load(db *gorm.DB) error {
+	if len(r.records) == 0 {
+		return nil
+	}
+	return db.Create(&r.records).Error
+}
+
+// Rows 使用 Go 切片声明实体的测试数据
+func Rows[T any](records ...*T) Fixture {
+	return rows[T]{records: records}
+}
+
+// RowsFromJSON 从 JSON 数组中解析实体的测试数据，字段名与实体的 json 标签一致
+func RowsFromJSON[T any](data []byte) (Fixture, error) {
+	var records []*T
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("gplustest: parse fixture of %T: %w", new(T), err)
+	}
+	return rows[T]{records: records}, nil
+}
+
+// MustRowsFromJSON 同 RowsFromJSON，解析失败时 panic
+func MustRowsFromJSON[T any](data []byte) Fixture {
+	fixture, err := RowsFromJSON[T](data)
+	if err != nil {
+		panic(err)
+	}
+	return fixture
+}
+
+// RowsFromYAML 从 YAML 列表中解析实体的测试数据，字段名与 RowsFromJSON 一致，同样按照实体的 json 标签匹配
+func RowsFromYAML[T any](data []byte) (Fixture, error) {
+	var values []map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("gplustest: parse fixture of %T: %w", new(T), err)
+	}
+	// 转换为 JSON 后再解析，保证两种格式的字段匹配规则相同
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("gplustest: parse fixture of %T: %w", new(T), err)
+	}
+	return RowsFromJSON[T](data)
+}
+
+// MustRowsFromYAML 同 RowsFromYAML，解析失败时 panic
+func MustRowsFromYAML[T any](data []byte) Fixture {
+	fixture, err := RowsFromYAML[T](data)
+	if err != nil {
+		panic(err)
+	}
+	return fixture
+}
+
+// Seed 清空测试数据涉及的表并重新插入数据，按外键依赖顺序执行：先删除子表再删除父表，先插入父表再插入子表
+// 清空使用 DELETE 而不是 TRUNCATE，因为 MySQL 的 TRUNCATE 会隐式提交事务
+func Seed(db *gorm.DB, fixtures ...Fixture) error {
+	sorted, err := sortFixtures(db, fixtures)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		cleanDb := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped()
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if err := cleanDb.Delete(sorted[i].model()).Error; err != nil {
+				return err
+			}
+		}
+		for _, fixture := range sorted {
+			if err := fixture.load(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SeedTx 开启事务并在事务中初始化测试数据，测试结束时自动回滚，保证测试之间互不影响
+// 返回的事务需要通过 gplus.Db(tx) 传递给 gplus 的方法
+func SeedTx(t testing.TB, db *gorm.DB, fixtures ...Fixture) *gorm.DB {
+	t.Helper()
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("gplustest: begin transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() {
+		tx.Rollback()
+	})
+	if err := Seed(tx, fixtures...); err != nil {
+		t.Fatalf("gplustest: seed fixtures: %v", err)
+	}
+	return tx
+}
+
+// sortFixtures 根据 belongs to 关系对测试数据进行拓扑排序，被依赖的表排在前面
+func sortFixtures(db *gorm.DB, fixtures []Fixture) ([]Fixture, error) {
+	tables := make([]string, len(fixtures))
+	indexes := make(map[string]int, len(fixtures))
+	schemas := make([]*schema.Schema, len(fixtures))
+	for i, fixture := range fixtures {
+		s, err := schema.Parse(fixture.model(), &schemaCache, db.NamingStrategy)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := indexes[s.Table]; ok {
+			return nil, fmt.Errorf("gplustest: duplicated fixture for table %s", s.Table)
+		}
+		schemas[i] = s
+		tables[i] = s.Table
+		indexes[s.Table] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make([]int, len(fixtures))
+	sorted := make([]Fixture, 0, len(fixtures))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch states[i] {
+		case visiting:
+			return fmt.Errorf("gplustest: circular foreign key dependency on table %s", tables[i])
+		case visited:
+			return nil
+		}
+		states[i] = visiting
+		for _, rel := range schemas[i].Relationships.BelongsTo {
+			if j, ok := indexes[rel.FieldSchema.Table]; ok && j != i {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		states[i] = visited
+		sorted = append(sorted, fixtures[i])
+		return nil
+	}
+	for i := range fixtures {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
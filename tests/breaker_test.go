@@ -0,0 +1,86 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/acmestack/gorm-plus/gplus"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+// failingConnPool 模拟连接中断的数据库，所有 SQL 都返回 driver.ErrBadConn
+type failingConnPool struct{}
+
+func (failingConnPool) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (failingConnPool) ExecContext(context.Context, string, ...any) (sql.Result, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (failingConnPool) QueryContext(context.Context, string, ...any) (*sql.Rows, error) {
+	return nil, driver.ErrBadConn
+}
+
+func (failingConnPool) QueryRowContext(context.Context, string, ...any) *sql.Row {
+	return nil
+}
+
+func (failingConnPool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return nil, driver.ErrBadConn
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	db := openDb(t, mysql.New(mysql.Config{Conn: failingConnPool{}, SkipInitializeWithVersion: true}), &gorm.Config{})
+	plugin := gplus.NewCircuitBreakerPlugin(gplus.CircuitBreakerConfig{MinRequests: 2, FailureRate: 0.5, OpenDuration: time.Minute})
+	plugin.PerEntity = true
+	if err := db.Use(plugin); err != nil {
+		t.Fatalf("errors happened when use circuit breaker plugin: %v", err)
+	}
+
+	// 执行前参数校验失败的 SQL 不会访问数据库，不计入失败
+	for i := 0; i < 3; i++ {
+		gplus.SelectList[Employee](nil, gplus.Db(db), gplus.ForceIndex[Employee]("idx_not_exists"))
+	}
+
+	// 连接异常计入失败
+	for i := 0; i < 2; i++ {
+		_, resultDb := gplus.SelectList[Employee](nil, gplus.Db(db))
+		if !errors.Is(resultDb.Error, driver.ErrBadConn) {
+			t.Fatalf("should returns bad connection error, but got %v", resultDb.Error)
+		}
+	}
+
+	_, resultDb := gplus.SelectList[Employee](nil, gplus.Db(db))
+	if !errors.Is(resultDb.Error, gplus.ErrCircuitOpen) {
+		t.Errorf("should returns circuit open error, but got %v", resultDb.Error)
+	}
+
+	// 按实体熔断，其他实体不受影响
+	_, resultDb = gplus.SelectList[User](nil, gplus.Db(db))
+	if errors.Is(resultDb.Error, gplus.ErrCircuitOpen) {
+		t.Errorf("circuit breaker of other entity should be closed, but got %v", resultDb.Error)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/acmestack/gorm-plus/gplus"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+)
+
+func TestColumnPolicy(t *testing.T) {
+	db := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	if err := db.Use(&gplus.ColumnPolicyPlugin{}); err != nil {
+		t.Fatalf("errors happened when use column policy plugin: %v", err)
+	}
+	_, m := gplus.NewQuery[Member]()
+	gplus.RegisterColumnPolicy[Member]("guest", &m.ID, &m.Username)
+	gplus.RegisterColumnPolicy[Member]("admin", &m.ID, &m.Username, &m.Score)
+	ctx := gplus.WithRole(context.Background(), "guest")
+
+	checkSql := func(resultDb *gorm.DB, expect string) {
+		t.Helper()
+		if resultDb.Error != nil {
+			t.Fatalf("errors happened when select: %v", resultDb.Error)
+		}
+		if sql := strings.TrimSpace(buildSql(resultDb)); sql != expect {
+			t.Errorf("errors happened when select expect: %v, got %v", expect, sql)
+		}
+	}
+	checkForbidden := func(resultDb *gorm.DB) {
+		t.Helper()
+		if !errors.Is(resultDb.Error, gplus.ErrColumnForbidden) {
+			t.Errorf("should returns column forbidden error, but got %v", resultDb.Error)
+		}
+	}
+
+	_, resultDb := gplus.SelectList[Member](nil, gplus.Db(db), gplus.Context(ctx))
+	checkSql(resultDb, "SELECT `id`,`username` FROM `members` WHERE `members`.`deleted_at` IS NULL")
+
+	// 指定的字段中不可见的字段会被移除
+	_, resultDb = gplus.SelectList[Member](nil, gplus.Db(db), gplus.Context(ctx), gplus.Select(&m.Username, &m.Score))
+	checkSql(resultDb, "SELECT `username` FROM `members` WHERE `members`.`deleted_at` IS NULL")
+
+	// COUNT 不会查询字段的值
+	_, resultDb = gplus.SelectCount[Member](nil, gplus.Db(db), gplus.Context(ctx))
+	checkSql(resultDb, "SELECT count(*) FROM `members` WHERE `members`.`deleted_at` IS NULL")
+
+	// 只通过表名查询时同样生效
+	var maps []map[string]any
+	checkSql(db.WithContext(ctx).Table("members").Find(&maps), "SELECT id,username FROM `members`")
+
+	// *、表达式以及 SELECT 子句中不可见的字段无法查询
+	var scores []int
+	checkForbidden(db.WithContext(ctx).Model(&Member{}).Select("*").Find(&[]*Member{}))
+	checkForbidden(db.WithContext(ctx).Model(&Member{}).Select("members.*").Find(&[]*Member{}))
+	checkForbidden(db.WithContext(ctx).Model(&Member{}).Select("score + 0 AS username").Find(&[]*Member{}))
+	checkForbidden(db.WithContext(ctx).Model(&Member{}).Select("score > ? AS username", 60).Find(&[]*Member{}))
+	checkForbidden(db.WithContext(ctx).Model(&Member{}).Pluck("score", &scores))
+	checkForbidden(db.WithContext(ctx).Model(&Member{}).Select("score").Count(new(int64)))
+	checkForbidden(db.WithContext(ctx).Raw("SELECT score FROM members").Find(&[]*Member{}))
+	checkForbidden(db.WithContext(ctx).Table("members").Select("score").Scan(&maps))
+	checkSql(db.WithContext(ctx).Model(&Member{}).Pluck("username", &[]string{}), "SELECT `username` FROM `members` WHERE `members`.`deleted_at` IS NULL")
+
+	// 未注册的角色无法查询
+	_, resultDb = gplus.SelectList[Member](nil, gplus.Db(db), gplus.Context(gplus.WithRole(context.Background(), "visitor")))
+	checkForbidden(resultDb)
+
+	// 查询结果中不可见字段的值会被清空
+	members := []*Member{{ID: 1, Username: "afumu", Score: 100}}
+	db.WithContext(ctx).Find(&members)
+	AssertEqual(t, members[0].Username, "afumu")
+	AssertEqual(t, members[0].Score, 0)
+
+	// 没有绑定角色时不受影响
+	_, resultDb = gplus.SelectList[Member](nil, gplus.Db(db))
+	checkSql(resultDb, "SELECT * FROM `members` WHERE `members`.`deleted_at` IS NULL")
+}
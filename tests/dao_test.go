@@ -18,9 +18,11 @@
 package tests
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/acmestack/gorm-plus/gplus"
+	"github.com/acmestack/gorm-plus/gplustest"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -28,6 +30,7 @@ import (
 	"sort"
 	"strconv"
 	"testing"
+	"time"
 )
 
 var gormDb *gorm.DB
@@ -42,7 +45,7 @@ func init() {
 		fmt.Println(err)
 	}
 	var u User
-	gormDb.AutoMigrate(u)
+	gormDb.AutoMigrate(u, Member{}, MemberCard{}, AliveMember{})
 	gplus.Init(gormDb)
 }
 
@@ -178,6 +181,26 @@ func TestUpdateById(t *testing.T) {
 
 }
 
+func TestUpdateByIdTrackChanges(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch[User](users)
+
+	updateUser := &User{ID: users[0].ID, Score: 100, Age: users[0].Age}
+	res := gplus.UpdateById[User](updateUser, gplus.TrackChanges())
+	if res.Error != nil || res.RowsAffected != 1 {
+		t.Errorf("errors happened when UpdateById: %v, affected: %v", res.Error, res.RowsAffected)
+	}
+	AssertEqual(t, gplus.GetChanges(res), map[string]gplus.FieldChange{"score": {Old: users[0].Score, New: 100}})
+
+	// 没有变化时不执行更新
+	res = gplus.UpdateById[User](updateUser, gplus.TrackChanges())
+	if res.Error != nil || res.RowsAffected != 0 {
+		t.Errorf("errors happened when UpdateById: %v, affected: %v", res.Error, res.RowsAffected)
+	}
+	AssertEqual(t, len(gplus.GetChanges(res)), 0)
+}
+
 func TestUpdateZeroById(t *testing.T) {
 	deleteOldData()
 	users := getUsers()
@@ -216,6 +239,210 @@ func TestUpdate(t *testing.T) {
 	AssertObjEqual(t, newUser, user, "ID", "Username", "Password", "Address", "Age", "Phone", "Score", "Dept", "CreatedAt", "UpdatedAt")
 }
 
+func TestDeleteCaptureAffectedIds(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch[User](users)
+
+	query, u := gplus.NewQuery[User]()
+	query.Eq(&u.Username, "afumu7")
+	res := gplus.Delete[User](query, gplus.CaptureAffectedIds())
+	if res.Error != nil || res.RowsAffected != 2 {
+		t.Errorf("errors happened when Delete: %v, affected: %v", res.Error, res.RowsAffected)
+	}
+
+	ids := gplus.GetAffectedIds(res)
+	AssertEqual(t, ids, []any{users[6].ID, users[7].ID})
+
+	// 没有绑定参数时驱动使用文本协议，主键仍然按字段类型返回
+	res = gplus.DeleteById[User](users[0].ID, gplus.CaptureAffectedIds())
+	AssertEqual(t, gplus.GetAffectedIds(res), []any{users[0].ID})
+	query, u = gplus.NewQuery[User]()
+	query.IsNotNull(&u.ID)
+	res = gplus.Delete[User](query, gplus.CaptureAffectedIds())
+	AssertEqual(t, gplus.GetAffectedIds(res), []any{users[1].ID, users[2].ID, users[3].ID, users[4].ID, users[5].ID})
+}
+
+func TestUpdateCaptureAffectedIds(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch[User](users)
+
+	q, u := gplus.NewQuery[User]()
+	q.Eq(&u.Dept, "生产部门").Set(&u.Score, 60)
+	res := gplus.Update(q, gplus.CaptureAffectedIds())
+	if res.Error != nil || res.RowsAffected != 2 {
+		t.Errorf("errors happened when Update: %v, affected: %v", res.Error, res.RowsAffected)
+	}
+
+	ids := gplus.GetAffectedIds(res)
+	AssertEqual(t, ids, []any{users[4].ID, users[5].ID})
+
+	res = gplus.UpdateById(&User{ID: users[0].ID, Score: 61}, gplus.CaptureAffectedIds())
+	if res.Error != nil || res.RowsAffected != 1 {
+		t.Errorf("errors happened when UpdateById: %v, affected: %v", res.Error, res.RowsAffected)
+	}
+	AssertEqual(t, gplus.GetAffectedIds(res), []any{users[0].ID})
+}
+
+func TestCachedDao(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch[User](users)
+
+	dao := gplus.NewCachedDao[User](gplus.NewLocalCache[User](time.Minute))
+	user, db := dao.SelectById(users[0].ID)
+	if db.Error != nil {
+		t.Fatalf("errors happened when SelectById: %v", db.Error)
+	}
+	AssertObjEqual(t, user, users[0], "ID", "Username", "Age", "Score")
+
+	// 绕过缓存直接更新，缓存中仍然是旧数据
+	gplus.UpdateById(&User{ID: users[0].ID, Score: 99})
+	user, _ = dao.SelectById(users[0].ID)
+	AssertEqual(t, user.Score, users[0].Score)
+
+	// 通过缓存 Dao 更新，缓存被清除
+	dao.UpdateById(&User{ID: users[0].ID, Score: 100})
+	user, _ = dao.SelectById(users[0].ID)
+	AssertEqual(t, user.Score, 100)
+
+	userIds := []int64{users[1].ID, users[0].ID}
+	resultUsers, db := dao.SelectByIds(userIds)
+	if db.Error != nil || len(resultUsers) != 2 {
+		t.Fatalf("errors happened when SelectByIds: %v, len: %v", db.Error, len(resultUsers))
+	}
+	AssertEqual(t, resultUsers[0].ID, users[1].ID)
+	AssertEqual(t, resultUsers[1].ID, users[0].ID)
+	AssertEqual(t, db.RowsAffected, int64(2))
+
+	// 全部命中缓存时 RowsAffected 同样为返回的记录数
+	_, db = dao.SelectByIds(userIds)
+	AssertEqual(t, db.RowsAffected, int64(2))
+
+	// 事务中查询到的未提交数据不会写入缓存
+	tx := gplus.Begin()
+	gplus.UpdateById(&User{ID: users[1].ID, Score: 77}, gplus.Db(tx))
+	user, _ = dao.SelectById(users[1].ID, gplus.Db(tx))
+	AssertEqual(t, user.Score, 77)
+	tx.Rollback()
+	user, _ = dao.SelectById(users[1].ID)
+	AssertEqual(t, user.Score, users[1].Score)
+
+	// 提交前其他请求重新写入的旧数据，在提交后被清除
+	err := dao.Tx(func(tx *gorm.DB) error {
+		dao.UpdateById(&User{ID: users[1].ID, Score: 88}, gplus.Db(tx))
+		dao.SelectById(users[1].ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("errors happened when Tx: %v", err)
+	}
+	user, _ = dao.SelectById(users[1].ID)
+	AssertEqual(t, user.Score, 88)
+
+	dao.DeleteById(users[0].ID)
+	_, db = dao.SelectById(users[0].ID)
+	if !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		t.Errorf("should returns record not found error, but got %v", db.Error)
+	}
+}
+
+func TestUpsertAlive(t *testing.T) {
+	gormDb.Unscoped().Where("1 = 1").Delete(&Member{})
+
+	member := &Member{Username: "afumu", Score: 60}
+	m := gplus.GetModel[Member]()
+	if res := gplus.UpsertAlive(member, []any{&m.Username}); res.Error != nil || res.RowsAffected != 1 {
+		t.Fatalf("errors happened when UpsertAlive: %v, affected: %v", res.Error, res.RowsAffected)
+	}
+
+	// 软删除后再次插入，恢复原有记录
+	gplus.DeleteById[Member](member.ID)
+	revived := &Member{Username: "afumu", Score: 80}
+	if res := gplus.UpsertAlive(revived, []any{&m.Username}); res.Error != nil {
+		t.Fatalf("errors happened when UpsertAlive: %v", res.Error)
+	}
+	AssertEqual(t, revived.ID, member.ID)
+
+	newMember, db := gplus.SelectById[Member](member.ID)
+	if db.Error != nil {
+		t.Fatalf("errors happened when SelectById: %v", db.Error)
+	}
+	AssertEqual(t, newMember.Score, 80)
+
+	// 存在未删除的记录时更新
+	updated := &Member{Username: "afumu", Score: 90}
+	gplus.UpsertAlive(updated, []any{&m.Username})
+	AssertEqual(t, updated.ID, member.ID)
+	count, _ := gplus.SelectCount[Member](nil)
+	AssertEqual(t, count, 1)
+}
+
+func TestUpsertAliveDeletedDuplicates(t *testing.T) {
+	gormDb.Unscoped().Where("1 = 1").Delete(&AliveMember{})
+
+	// 同一个用户名存在多条已删除的记录
+	var deletedIds []int64
+	for i := 0; i < 3; i++ {
+		member := &AliveMember{Username: "afumu", Score: i}
+		gplus.Insert(member)
+		gplus.DeleteById[AliveMember](member.ID)
+		deletedIds = append(deletedIds, member.ID)
+	}
+
+	// 只有已删除的记录时恢复最近删除的一条
+	m := gplus.GetModel[AliveMember]()
+	revived := &AliveMember{Username: "afumu", Score: 80}
+	if res := gplus.UpsertAlive(revived, []any{&m.Username}); res.Error != nil {
+		t.Fatalf("errors happened when UpsertAlive: %v", res.Error)
+	}
+	AssertEqual(t, revived.ID, deletedIds[2])
+
+	// 存在未删除的记录时更新未删除的记录，即使先查到的是已删除的记录
+	for i := 0; i < 3; i++ {
+		if res := gplus.UpsertAlive(&AliveMember{Username: "afumu", Score: 90 + i}, []any{&m.Username}); res.Error != nil {
+			t.Fatalf("errors happened when UpsertAlive: %v", res.Error)
+		}
+	}
+	members, _ := gplus.SelectList[AliveMember](nil)
+	AssertEqual(t, len(members), 1)
+	AssertEqual(t, members[0].ID, deletedIds[2])
+	AssertEqual(t, members[0].Score, 92)
+	total, _ := gplus.SelectCount[AliveMember](nil, gplus.Db(gormDb.Unscoped()))
+	AssertEqual(t, total, 3)
+}
+
+func TestUpsertAliveConflict(t *testing.T) {
+	gormDb.Unscoped().Where("1 = 1").Delete(&Member{})
+
+	// 在第一次查询已删除的记录未命中后插入相同的记录，模拟其他事务并发插入
+	db := openDb(t, gormDb.Dialector, &gorm.Config{})
+	var conflicted, inserts int
+	db.Callback().Query().After("gorm:query").Register("test:conflict", func(tx *gorm.DB) {
+		if conflicted == 0 && tx.Statement.Unscoped && errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			conflicted++
+			tx.Session(&gorm.Session{NewDB: true}).Create(&Member{Username: "afumu", Score: 60})
+		}
+	})
+	db.Callback().Create().Before("gorm:create").Register("test:inserts", func(tx *gorm.DB) {
+		inserts++
+	})
+
+	member := &Member{Username: "afumu", Score: 90}
+	m := gplus.GetModel[Member]()
+	if res := gplus.UpsertAlive(member, []any{&m.Username}, gplus.Db(db)); res.Error != nil {
+		t.Fatalf("errors happened when UpsertAlive: %v", res.Error)
+	}
+	// 模拟插入一次，UpsertAlive 插入冲突一次，回滚到保存点后重新查询并更新
+	AssertEqual(t, inserts, 2)
+
+	members, _ := gplus.SelectList[Member](nil)
+	AssertEqual(t, len(members), 1)
+	AssertEqual(t, members[0].ID, member.ID)
+	AssertEqual(t, members[0].Score, 90)
+}
+
 func TestSelectById(t *testing.T) {
 	deleteOldData()
 	users := getUsers()
@@ -577,6 +804,218 @@ func TestSelectGeneric7(t *testing.T) {
 	}
 }
 
+func TestChain(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+
+	q, u := gplus.NewQuery[User]()
+	q.Eq(&u.Username, users[0].Username).Set(&u.Score, 99)
+	results, err := gplus.Chain(context.Background()).
+		Then(gplus.InsertStep(users[0]), gplus.UpdateStep(q)).
+		Then(func(tx *gorm.DB, results []*gorm.DB) *gorm.DB {
+			return gplus.IncrementByIds[User]([]int64{users[0].ID}, &u.Score, results[1].RowsAffected, gplus.Db(tx))
+		}).
+		Run()
+	if err != nil || len(results) != 3 {
+		t.Fatalf("errors happened when Chain: %v, results: %v", err, len(results))
+	}
+	newUser, _ := gplus.SelectById[User](users[0].ID)
+	AssertEqual(t, newUser.Score, 100)
+
+	// 任意步骤出错时回滚
+	_, err = gplus.Chain(context.Background()).
+		Then(gplus.InsertStep(users[1]), gplus.InsertStep(users[0])).
+		Run()
+	if err == nil {
+		t.Errorf("should returns error when insert duplicated id")
+	}
+	if _, db := gplus.SelectById[User](users[1].ID); !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		t.Errorf("should returns record not found error, but got %v", db.Error)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	cards := gplustest.Rows(&MemberCard{ID: 1, CardNo: "A001", MemberID: 1}, &MemberCard{ID: 2, CardNo: "A002", MemberID: 2})
+	members := gplustest.MustRowsFromJSON[Member]([]byte(`[{"ID": 1, "Username": "afumu1"}, {"ID": 2, "Username": "afumu2"}]`))
+
+	before, _ := gplus.SelectCount[Member](nil)
+	t.Run("seed", func(t *testing.T) {
+		// 子表在前也能按外键顺序插入
+		tx := gplustest.SeedTx(t, gormDb, cards, members)
+		count, resultDb := gplus.SelectCount[MemberCard](nil, gplus.Db(tx))
+		if resultDb.Error != nil {
+			t.Fatalf("errors happened when SelectCount: %v", resultDb.Error)
+		}
+		AssertEqual(t, count, int64(2))
+
+		// 重复初始化会先清空旧数据
+		if err := gplustest.Seed(tx, members, gplustest.Rows[MemberCard]()); err != nil {
+			t.Fatalf("errors happened when Seed: %v", err)
+		}
+		count, _ = gplus.SelectCount[MemberCard](nil, gplus.Db(tx))
+		AssertEqual(t, count, int64(0))
+
+		// YAML 与 JSON 的字段匹配规则相同
+		yamlMembers := gplustest.MustRowsFromYAML[Member]([]byte(`
+- ID: 3
+  Username: afumu3
+  Score: 60
+`))
+		if err := gplustest.Seed(tx, yamlMembers); err != nil {
+			t.Fatalf("errors happened when Seed: %v", err)
+		}
+		member, resultDb := gplus.SelectById[Member](3, gplus.Db(tx))
+		if resultDb.Error != nil {
+			t.Fatalf("errors happened when SelectById: %v", resultDb.Error)
+		}
+		AssertEqual(t, member.Username, "afumu3")
+		AssertEqual(t, member.Score, 60)
+	})
+
+	// 测试结束后事务回滚
+	after, _ := gplus.SelectCount[Member](nil)
+	AssertEqual(t, after, before)
+}
+
+func TestCountCache(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch(users)
+
+	// 通过 gormDb 写入的数据不会使缓存失效
+	db := openDb(t, gormDb.Dialector, &gorm.Config{})
+	cache := gplus.NewCountCache(time.Minute)
+	if err := db.Use(cache); err != nil {
+		t.Fatalf("errors happened when use count cache: %v", err)
+	}
+
+	q, u := gplus.NewQuery[User]()
+	q.Ge(&u.Age, 18)
+	count, resultDb := gplus.SelectCount(q, gplus.Db(db), gplus.WithCountCache(cache))
+	if resultDb.Error != nil {
+		t.Fatalf("errors happened when SelectCount: %v", resultDb.Error)
+	}
+
+	gormDb.Create(&User{Username: "afumu9", Password: "123456", Age: 20, Score: 1, Dept: "开发部门"})
+	cachedCount, _ := gplus.SelectCount(q, gplus.Db(db), gplus.WithCountCache(cache))
+	AssertEqual(t, cachedCount, count)
+
+	// 同表写操作后缓存失效
+	gplus.Insert(&User{Username: "afumu10", Password: "123456", Age: 20, Score: 1, Dept: "开发部门"}, gplus.Db(db))
+	newCount, _ := gplus.SelectCount(q, gplus.Db(db), gplus.WithCountCache(cache))
+	AssertEqual(t, newCount, count+2)
+
+	// 超出缓存数量上限时淘汰旧的缓存
+	cache.MaxEntries = 1
+	otherQuery, ou := gplus.NewQuery[User]()
+	otherQuery.Lt(&ou.Age, 18)
+	gplus.SelectCount(otherQuery, gplus.Db(db), gplus.WithCountCache(cache))
+	gormDb.Create(&User{Username: "afumu11", Password: "123456", Age: 20, Score: 1, Dept: "开发部门"})
+	evictedCount, _ := gplus.SelectCount(q, gplus.Db(db), gplus.WithCountCache(cache))
+	AssertEqual(t, evictedCount, count+3)
+
+	// 事务提交前其他请求查询的总数不会写入缓存，提交后缓存失效
+	err := cache.Tx(func(tx *gorm.DB) error {
+		gplus.Insert(&User{Username: "afumu12", Password: "123456", Age: 20, Score: 1, Dept: "开发部门"}, gplus.Db(tx))
+		beforeCommit, _ := gplus.SelectCount(q, gplus.Db(db), gplus.WithCountCache(cache))
+		AssertEqual(t, beforeCommit, count+3)
+		return nil
+	}, gplus.Db(db))
+	if err != nil {
+		t.Fatalf("errors happened when count cache tx: %v", err)
+	}
+	committedCount, _ := gplus.SelectCount(q, gplus.Db(db), gplus.WithCountCache(cache))
+	AssertEqual(t, committedCount, count+4)
+}
+
+func TestSelectGenericMapKey(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch[User](users)
+
+	query, u := gplus.NewQuery[User]()
+	query.Select(&u.Dept, gplus.Sum(&u.Score).As("total_score")).Group(&u.Dept)
+	userMaps, resultDb := gplus.SelectGeneric[User, []map[string]any](query, gplus.MapKey(gplus.MapKeyJSON))
+	if resultDb.Error != nil {
+		t.Fatalf("errors happened when SelectGeneric: %v", resultDb.Error)
+	}
+	for _, userMap := range userMaps {
+		if _, ok := userMap["dept"].(string); !ok {
+			t.Errorf("dept should be string, got %#v", userMap["dept"])
+		}
+		if _, ok := userMap["totalScore"].(string); !ok {
+			t.Errorf("totalScore should be string, got %#v", userMap["totalScore"])
+		}
+	}
+
+	pageQuery, model := gplus.NewQuery[User]()
+	pageQuery.Eq(&model.Username, users[0].Username)
+	page := gplus.NewPage[map[string]any](1, 10)
+	resultPage, resultDb := gplus.SelectPageGeneric[User, map[string]any](page, pageQuery, gplus.MapKey(gplus.MapKeyField))
+	if resultDb.Error != nil {
+		t.Fatalf("errors happened when SelectPageGeneric: %v", resultDb.Error)
+	}
+	if len(resultPage.RecordsMap) != 1 {
+		t.Fatalf("records expects: %v, got %v", 1, len(resultPage.RecordsMap))
+	}
+	AssertEqual(t, resultPage.RecordsMap[0]["Username"], users[0].Username)
+	if _, ok := resultPage.RecordsMap[0]["username"]; ok {
+		t.Errorf("column name should be replaced by field name")
+	}
+}
+
+func TestInsertBatchPartial(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.Insert(users[0])
+
+	// 主键重复的记录插入失败，其他记录正常插入
+	duplicated := &User{ID: users[0].ID, Username: "afumu1", Password: "123456", Age: 18, Score: 12, Dept: "开发部门"}
+	entities := []*User{users[1], duplicated, users[2], users[3]}
+	report, resultDb := gplus.InsertBatchPartial(entities)
+	if resultDb.Error != nil {
+		t.Fatalf("errors happened when InsertBatchPartial: %v", resultDb.Error)
+	}
+	AssertEqual(t, resultDb.RowsAffected, int64(3))
+	AssertEqual(t, len(report.Succeeded), 3)
+	if len(report.Failed) != 1 {
+		t.Fatalf("failed records expects: %v, got %v", 1, len(report.Failed))
+	}
+	AssertEqual(t, report.Failed[0].Index, 1)
+	if report.Failed[0].Entity != duplicated || report.Failed[0].Err == nil {
+		t.Errorf("failed record should be the duplicated user with an error")
+	}
+
+	count, _ := gplus.SelectCount[User](nil)
+	AssertEqual(t, count, int64(4))
+}
+
+func TestSelectByIdsOrderAndMissing(t *testing.T) {
+	deleteOldData()
+	users := getUsers()
+	gplus.InsertBatch[User](users)
+
+	missingId := users[len(users)-1].ID + 100
+	ids := []int64{users[2].ID, missingId, users[0].ID, users[2].ID, users[1].ID}
+	results, resultDb := gplus.SelectByIds[User](ids, gplus.OrderByIds(), gplus.CaptureMissingIds())
+	if resultDb.Error != nil {
+		t.Fatalf("errors happened when SelectByIds: %v", resultDb.Error)
+	}
+	if len(results) != 3 {
+		t.Fatalf("results expects: %v, got %v", 3, len(results))
+	}
+	AssertObjEqual(t, results[0], users[2], "ID", "Username")
+	AssertObjEqual(t, results[1], users[0], "ID", "Username")
+	AssertObjEqual(t, results[2], users[1], "ID", "Username")
+	AssertEqual(t, gplus.GetMissingIds(resultDb), []any{missingId})
+
+	// 使用缓存时同样返回未查询到的 ID
+	dao := gplus.NewCachedDao[User](gplus.NewLocalCache[User](time.Minute))
+	dao.SelectById(users[0].ID)
+	_, resultDb = dao.SelectByIds([]int64{users[0].ID, missingId}, gplus.CaptureMissingIds())
+	AssertEqual(t, gplus.GetMissingIds(resultDb), []any{missingId})
+}
+
 func TestTx(t *testing.T) {
 	deleteOldData()
 	users := getUsers()
@@ -208,6 +208,16 @@ func TestDelete22Name(t *testing.T) {
 	gplus.Delete(query, gplus.Db(sessionDb))
 }
 
+func TestDeleteQueryMap(t *testing.T) {
+	var expectSql = "DELETE FROM `Users` WHERE score < 60 AND username <> 'afumu'"
+	sessionDb := checkDeleteSql(t, expectSql)
+	query, err := gplus.QueryMap[User](map[string]any{"username !=": "afumu", "score <": 60})
+	if err != nil {
+		t.Fatalf("errors happened when QueryMap: %v", err)
+	}
+	gplus.Delete(query, gplus.Db(sessionDb))
+}
+
 func checkDeleteSql(t *testing.T, expect string) *gorm.DB {
 	expect = strings.TrimSpace(expect)
 	sessionDb := gormDb.Session(&gorm.Session{DryRun: true})
@@ -18,7 +18,10 @@
 package tests
 
 import (
+	"database/sql/driver"
+	"errors"
 	"github.com/acmestack/gorm-plus/gplus"
+	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"strings"
 	"testing"
@@ -68,6 +71,15 @@ func TestInsertBatchSizeName(t *testing.T) {
 	gplus.InsertBatchSize([]*User{user, user2, user3, user4}, 2, gplus.Db(sessionDb), gplus.Select(&u.Username, &u.Password), gplus.Omit(&u.CreatedAt, &u.UpdatedAt))
 }
 
+func TestSaveInsert(t *testing.T) {
+	var expectSql = "INSERT INTO `Users` (`username`,`password`) VALUES ('afumu','123456')"
+	user := &User{Username: "afumu", Password: "123456"}
+	u := gplus.GetModel[User]()
+	sessionDb := checkInsertSql(t, expectSql)
+	action, _ := gplus.Save(user, gplus.Db(sessionDb), gplus.Select(&u.Username, &u.Password), gplus.Omit(&u.CreatedAt, &u.UpdatedAt))
+	AssertEqual(t, action, gplus.SaveInserted)
+}
+
 func checkInsertSql(t *testing.T, expect string) *gorm.DB {
 	expect = strings.TrimSpace(expect)
 	sessionDb := gormDb.Session(&gorm.Session{DryRun: true})
@@ -82,3 +94,16 @@ func checkInsertSql(t *testing.T, expect string) *gorm.DB {
 	})
 	return sessionDb
 }
+
+func TestInsertBatchPartialConnError(t *testing.T) {
+	db := openDb(t, mysql.New(mysql.Config{Conn: failingConnPool{}, SkipInitializeWithVersion: true}), &gorm.Config{})
+	// 连接异常与记录无关，不会二分重试并把每条记录标记为失败
+	users := []*User{{Username: "afumu1", Age: 18}, {Username: "afumu2", Age: 18}, {Username: "afumu3", Age: 18}}
+	report, resultDb := gplus.InsertBatchPartial[User](users, gplus.Db(db))
+	if !errors.Is(resultDb.Error, driver.ErrBadConn) {
+		t.Errorf("should returns bad connection error, but got %v", resultDb.Error)
+	}
+	if len(report.Succeeded) != 0 || len(report.Failed) != 0 {
+		t.Errorf("report should be empty, got succeeded %d, failed %d", len(report.Succeeded), len(report.Failed))
+	}
+}
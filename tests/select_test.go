@@ -18,10 +18,13 @@
 package tests
 
 import (
+	"context"
+	"errors"
 	"github.com/acmestack/gorm-plus/gplus"
 	"gorm.io/gorm"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestSelectByIdName(t *testing.T) {
@@ -291,6 +294,200 @@ func TestSelectListQueryModelCount(t *testing.T) {
 	gplus.SelectGeneric[User, []UserVo](query, gplus.Db(sessionDb))
 }
 
+func TestSelectListQueryMap(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` WHERE age >= 18 AND dept IN ('开发','测试') AND username = 'afumu'"
+	sessionDb := checkSelectSql(t, expectSql)
+	query, err := gplus.QueryMap[User](map[string]any{
+		"username": "afumu",
+		"age >=":   18,
+		"dept in":  []string{"开发", "测试"},
+	})
+	if err != nil {
+		t.Fatalf("errors happened when QueryMap: %v", err)
+	}
+	gplus.SelectList[User](query, gplus.Db(sessionDb))
+}
+
+func TestSelectListQueryMapNull(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` WHERE address IS NULL AND age BETWEEN 18 AND 20"
+	sessionDb := checkSelectSql(t, expectSql)
+	query, err := gplus.QueryMap[User](map[string]any{
+		"address":     nil,
+		"age between": []int{18, 20},
+	})
+	if err != nil {
+		t.Fatalf("errors happened when QueryMap: %v", err)
+	}
+	gplus.SelectList[User](query, gplus.Db(sessionDb))
+}
+
+func TestQueryMapInvalid(t *testing.T) {
+	if _, err := gplus.QueryMap[User](map[string]any{"name; drop table": 1}); err == nil {
+		t.Errorf("QueryMap should return error when column not exists")
+	}
+	if _, err := gplus.QueryMap[User](map[string]any{"age ~": 1}); err == nil {
+		t.Errorf("QueryMap should return error when operator not supported")
+	}
+	if _, err := gplus.QueryMap[User](map[string]any{"age between": 1}); err == nil {
+		t.Errorf("QueryMap should return error when between values is invalid")
+	}
+}
+
+func TestSelectListWithIndexHint(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` FORCE INDEX(idx_username) WHERE username = 'afumu'"
+	sessionDb := checkSelectSql(t, expectSql)
+	query, u := gplus.NewQuery[User]()
+	query.Eq(&u.Username, "afumu")
+	gplus.SelectList[User](query, gplus.Db(sessionDb), gplus.WithIndexHint("FORCE INDEX(idx_username)"))
+}
+
+func TestSelectListForceIndex(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` FORCE INDEX (`idx_username`,`idx_age`) WHERE username = 'afumu'"
+	gplus.RegisterIndex[User]("idx_username", "idx_age")
+	sessionDb := checkSelectSql(t, expectSql)
+	query, u := gplus.NewQuery[User]()
+	query.Eq(&u.Username, "afumu")
+	gplus.SelectList[User](query, gplus.Db(sessionDb), gplus.ForceIndex[User]("idx_username", "idx_age"))
+}
+
+func TestSelectListForceIndexNotRegistered(t *testing.T) {
+	sessionDb := gormDb.Session(&gorm.Session{DryRun: true})
+	query, u := gplus.NewQuery[User]()
+	query.Eq(&u.Username, "afumu")
+	_, resultDb := gplus.SelectList[User](query, gplus.Db(sessionDb), gplus.ForceIndex[User]("idx_not_exists"))
+	if resultDb.Error == nil {
+		t.Errorf("should returns error when index is not registered")
+	}
+}
+
+func TestSelectListWithAsOf(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` FOR SYSTEM_TIME AS OF TIMESTAMP '2023-01-02 03:04:05 +0000 UTC' WHERE username = 'afumu'"
+	sessionDb := checkSelectSql(t, expectSql)
+	query, u := gplus.NewQuery[User]()
+	query.Eq(&u.Username, "afumu")
+	asOf := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	gplus.SelectList[User](query, gplus.Db(sessionDb), gplus.WithAsOf(asOf))
+}
+
+func TestSelectListWithAsOfHistoryTable(t *testing.T) {
+	var expectSql = "SELECT * FROM (SELECT * FROM `employees` WHERE `valid_from` <= '2023-01-02 03:04:05 +0000 UTC' UNION ALL SELECT * FROM `employees_history` WHERE `valid_from` <= '2023-01-02 03:04:05 +0000 UTC' AND `valid_to` > '2023-01-02 03:04:05 +0000 UTC') AS `employees` WHERE name = 'afumu'"
+	query, e := gplus.NewQuery[Employee]()
+	gplus.RegisterHistoryTable[Employee]("employees_history", &e.ValidFrom, &e.ValidTo)
+	sessionDb := checkSelectSql(t, expectSql)
+	query.Eq(&e.Name, "afumu")
+	asOf := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	gplus.SelectList[Employee](query, gplus.Db(sessionDb), gplus.WithAsOf(asOf))
+}
+
+func TestSelectListWithTotal(t *testing.T) {
+	sessionDb, sqls := collectSelectSql(t)
+	query, u := gplus.NewQuery[User]()
+	query.Eq(&u.Dept, "开发").OrderByDesc(&u.Age).Limit(10).Offset(20)
+	gplus.SelectListWithTotal[User](query, gplus.Db(sessionDb))
+	AssertEqual(t, *sqls, []string{
+		"SELECT count(*) FROM `Users` WHERE dept = '开发'",
+		"SELECT * FROM `Users` WHERE dept = '开发' ORDER BY age DESC LIMIT 10 OFFSET 20",
+	})
+}
+
+func collectSelectSql(t *testing.T) (*gorm.DB, *[]string) {
+	var sqls []string
+	sessionDb := gormDb.Session(&gorm.Session{DryRun: true})
+	callback := sessionDb.Callback().Query().After("gorm:query")
+	callback.Register("collect_sql", func(db *gorm.DB) {
+		sqls = append(sqls, strings.Join(strings.Fields(buildSql(db)), " "))
+	})
+	t.Cleanup(func() {
+		callback.Remove("collect_sql")
+	})
+	return sessionDb, &sqls
+}
+
+func TestSelectChan(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` WHERE age > 18  ORDER BY `Users`.`id` LIMIT 1000"
+	sessionDb := checkSelectSql(t, expectSql)
+	query, u := gplus.NewQuery[User]()
+	query.Gt(&u.Age, 18)
+	records, errs := gplus.SelectChan[User](context.Background(), query, 10, gplus.Db(sessionDb))
+	for range records {
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("errors happened when SelectChan: %v", err)
+	}
+
+	// 指定排序时返回错误，buffer 小于 0 时按 0 处理
+	orderQuery, o := gplus.NewQuery[User]()
+	orderQuery.Gt(&o.Age, 18).OrderByDesc(&o.Age)
+	records, errs = gplus.SelectChan[User](context.Background(), orderQuery, -1, gplus.Db(gormDb.Session(&gorm.Session{DryRun: true})))
+	for range records {
+		t.Errorf("should not receive records when order by is set")
+	}
+	if err := <-errs; !errors.Is(err, gplus.ErrChanOrderBy) {
+		t.Errorf("should returns order by error, but got %v", err)
+	}
+}
+
+func TestSelectChanBatches(t *testing.T) {
+	// 模拟查询结果，remaining 小于 0 时每批都返回完整的一批数据
+	db := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	var nextId, remaining int
+	db.Callback().Query().After("gorm:query").Register("fake_rows", func(tx *gorm.DB) {
+		results, ok := tx.Statement.Dest.(*[]*User)
+		if !ok {
+			return
+		}
+		size := 1000
+		if remaining >= 0 && remaining < size {
+			size = remaining
+		}
+		*results = nil
+		for i := 0; i < size; i++ {
+			nextId++
+			*results = append(*results, &User{ID: int64(nextId)})
+		}
+		if remaining >= 0 {
+			remaining -= size
+		}
+		tx.RowsAffected = int64(size)
+	})
+
+	remaining = 1005
+	records, errs := gplus.SelectChan[User](context.Background(), nil, 0, gplus.Db(db))
+	var count int
+	for record := range records {
+		count++
+		AssertEqual(t, record.ID, int64(count))
+	}
+	AssertEqual(t, count, 1005)
+	if err, ok := <-errs; ok || err != nil {
+		t.Errorf("error channel should be closed without error, got %v", err)
+	}
+
+	// ctx 取消后停止查询并关闭两个 channel
+	nextId, remaining = 0, -1
+	ctx, cancel := context.WithCancel(context.Background())
+	records, errs = gplus.SelectChan[User](ctx, nil, 0, gplus.Db(db))
+	for i := 0; i < 3; i++ {
+		<-records
+	}
+	cancel()
+	timeout := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-records:
+			closed = !ok
+		case <-timeout:
+			t.Fatalf("records channel should be closed after ctx canceled")
+		}
+	}
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("should returns context canceled error, but got %v", err)
+	}
+	if _, ok := <-errs; ok {
+		t.Errorf("error channel should be closed")
+	}
+}
+
 func checkSelectSql(t *testing.T, expect string) *gorm.DB {
 	expect = strings.TrimSpace(expect)
 	sessionDb := gormDb.Session(&gorm.Session{DryRun: true})
@@ -306,3 +503,57 @@ func checkSelectSql(t *testing.T, expect string) *gorm.DB {
 
 	return sessionDb
 }
+
+func TestCachedDaoEvictWhileLoading(t *testing.T) {
+	cache := gplus.NewLocalCache[User](0)
+	dao := gplus.NewCachedDao[User](cache)
+
+	// 查询数据库期间其他请求更新了记录并清除缓存，查询到的旧数据不会写入缓存
+	db := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	evicted := false
+	db.Callback().Query().After("gorm:query").Register("evict_cache", func(tx *gorm.DB) {
+		if !evicted {
+			evicted = true
+			dao.DeleteById(1)
+		}
+	})
+	old := gplus.ReplaceDB(db)
+	defer gplus.ReplaceDB(old)
+
+	dao.SelectById(1)
+	if _, ok := cache.Get("tests.User:1"); ok {
+		t.Errorf("entity loaded before eviction should not be cached")
+	}
+	dao.SelectById(1)
+	if _, ok := cache.Get("tests.User:1"); !ok {
+		t.Errorf("entity should be cached")
+	}
+}
+
+func TestSelectNotExists(t *testing.T) {
+	var expectSql = "SELECT * FROM `Users` WHERE age > 18 AND NOT EXISTS ( SELECT 1 FROM `member_cards` WHERE card_no = 'A001' )"
+	sessionDb, sqls := collectSelectSql(t)
+	query, u := gplus.NewQuery[User]()
+	subQuery, c := gplus.NewQuery[MemberCard]()
+	subQuery.Eq(&c.CardNo, "A001")
+	query.Gt(&u.Age, 18).NotExists(gplus.SubQuery(subQuery).Select("1"))
+	gplus.SelectList[User](query, gplus.Db(sessionDb))
+	if len(*sqls) == 0 {
+		t.Fatalf("sql should be recorded")
+	}
+	AssertEqual(t, (*sqls)[len(*sqls)-1], expectSql)
+}
+
+func TestSelectOrphans(t *testing.T) {
+	var expectSql = "SELECT * FROM `member_cards` WHERE member_id IS NOT NULL AND NOT EXISTS ( SELECT 1 FROM `members` WHERE `members`.`id` = `member_cards`.`member_id` AND `members`.`deleted_at` IS NULL )"
+	sessionDb, sqls := collectSelectSql(t)
+	_, c := gplus.NewQuery[MemberCard]()
+	_, resultDb := gplus.SelectOrphans[MemberCard, Member](&c.MemberID, gplus.Db(sessionDb))
+	if resultDb.Error != nil {
+		t.Fatalf("errors happened when SelectOrphans: %v", resultDb.Error)
+	}
+	if len(*sqls) == 0 {
+		t.Fatalf("sql should be recorded")
+	}
+	AssertEqual(t, (*sqls)[len(*sqls)-1], expectSql)
+}
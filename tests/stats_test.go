@@ -0,0 +1,75 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tests
+
+import (
+	"context"
+	"github.com/acmestack/gorm-plus/gplus"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsCollector(t *testing.T) {
+	sessionDb := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	if err := sessionDb.Use(&gplus.StatsPlugin{}); err != nil {
+		t.Fatalf("errors happened when use stats plugin: %v", err)
+	}
+
+	collector := gplus.NewStatsCollector()
+	ctx := gplus.WithStatsCollector(context.Background(), collector)
+	for i := 1; i <= 3; i++ {
+		gplus.SelectById[User](i, gplus.Db(sessionDb), gplus.Context(ctx))
+	}
+	gplus.SelectByIds[User]([]int{1, 2}, gplus.Db(sessionDb), gplus.Context(ctx))
+	gplus.SelectByIds[User]([]int{1, 2, 3}, gplus.Db(sessionDb), gplus.Context(ctx))
+	// 没有绑定收集器的 SQL 不会被记录
+	gplus.SelectById[User](1, gplus.Db(sessionDb))
+
+	summary := collector.Summary()
+	AssertEqual(t, summary.Count, 5)
+	AssertEqual(t, summary.Tables["Users"], 5)
+	AssertEqual(t, summary.Repeated, map[string]int{"SELECT * FROM `Users` WHERE id = ? LIMIT 1": 3})
+	if !strings.Contains(summary.String(), "possible N+1") {
+		t.Errorf("summary should contains N+1 warning, got %v", summary.String())
+	}
+}
+
+func TestStatsCollectorCountCache(t *testing.T) {
+	sessionDb := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	cache := gplus.NewCountCache(time.Minute)
+	if err := sessionDb.Use(&gplus.StatsPlugin{}); err != nil {
+		t.Fatalf("errors happened when use stats plugin: %v", err)
+	}
+	if err := sessionDb.Use(cache); err != nil {
+		t.Fatalf("errors happened when use count cache: %v", err)
+	}
+
+	collector := gplus.NewStatsCollector()
+	ctx := gplus.WithStatsCollector(context.Background(), collector)
+	q, u := gplus.NewQuery[User]()
+	q.Ge(&u.Age, 18)
+	// 生成缓存键的查询不会被记录，命中缓存时不执行 SQL
+	gplus.SelectCount(q, gplus.Db(sessionDb), gplus.Context(ctx), gplus.WithCountCache(cache))
+	gplus.SelectCount(q, gplus.Db(sessionDb), gplus.Context(ctx), gplus.WithCountCache(cache))
+
+	summary := collector.Summary()
+	AssertEqual(t, summary.Count, 1)
+	AssertEqual(t, summary.Repeated, map[string]int{})
+}
@@ -18,7 +18,11 @@
 package tests
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
 	"github.com/acmestack/gorm-plus/gplus"
+	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"strings"
 	"testing"
@@ -113,6 +117,29 @@ func TestUpdate7Name(t *testing.T) {
 	gplus.Update(query, gplus.Db(sessionDb), gplus.Omit(&u.CreatedAt, &u.UpdatedAt))
 }
 
+func TestUpdateColumnByIds(t *testing.T) {
+	var expectSql = "UPDATE `Users` SET `score`=100 WHERE id IN (1,2)"
+	sessionDb := checkUpdateSql(t, expectSql)
+	u := gplus.GetModel[User]()
+	gplus.UpdateColumnByIds[User]([]int64{1, 2}, &u.Score, 100, gplus.Db(sessionDb), gplus.Omit(&u.CreatedAt, &u.UpdatedAt))
+}
+
+func TestIncrementByIds(t *testing.T) {
+	var expectSql = "UPDATE `Users` SET `score`=score + 5 WHERE id IN (1,2)"
+	sessionDb := checkUpdateSql(t, expectSql)
+	u := gplus.GetModel[User]()
+	gplus.IncrementByIds[User]([]int64{1, 2}, &u.Score, 5, gplus.Db(sessionDb), gplus.Omit(&u.CreatedAt, &u.UpdatedAt))
+}
+
+func TestSaveUpdate(t *testing.T) {
+	var expectSql = "UPDATE `Users` SET `score`=100 WHERE `id` = 1"
+	sessionDb := checkUpdateSql(t, expectSql)
+	var user = &User{ID: 1, Score: 100}
+	u := gplus.GetModel[User]()
+	action, _ := gplus.Save(user, gplus.Db(sessionDb), gplus.Omit(&u.CreatedAt, &u.UpdatedAt))
+	AssertEqual(t, action, gplus.SaveUpdated)
+}
+
 func checkUpdateSql(t *testing.T, expect string) *gorm.DB {
 	expect = strings.TrimSpace(expect)
 	sessionDb := gormDb.Session(&gorm.Session{DryRun: true})
@@ -128,3 +155,53 @@ func checkUpdateSql(t *testing.T, expect string) *gorm.DB {
 
 	return sessionDb
 }
+
+func TestChainOptions(t *testing.T) {
+	var sqls []string
+	db := openDb(t, mysql.New(mysql.Config{Conn: recordingConnPool{sqls: &sqls}, SkipInitializeWithVersion: true}), &gorm.Config{SkipDefaultTransaction: true})
+
+	// 链上指定的 Select 只用于开启事务，不会影响每个步骤插入和更新的字段
+	q, u := gplus.NewQuery[User]()
+	q.Eq(&u.ID, 1).Set(&u.Score, 99)
+	_, err := gplus.Chain(context.Background(), gplus.Db(db), gplus.Select(&u.Username)).
+		Then(gplus.InsertStep(&User{Username: "afumu", Age: 18}), gplus.UpdateStep(q)).
+		Run()
+	if err != nil {
+		t.Fatalf("errors happened when Chain: %v", err)
+	}
+	if len(sqls) != 2 {
+		t.Fatalf("should execute 2 sql, got %v", sqls)
+	}
+	if !strings.Contains(sqls[0], "`age`") {
+		t.Errorf("insert step should not be affected by chain options: %v", sqls[0])
+	}
+	if !strings.HasPrefix(sqls[1], "UPDATE `Users` SET `score`=?") {
+		t.Errorf("update step should not be affected by chain options: %v", sqls[1])
+	}
+}
+
+func TestUpsertAliveSql(t *testing.T) {
+	var sqls []string
+	db := openDb(t, mysql.New(mysql.Config{Conn: recordingConnPool{sqls: &sqls}, SkipInitializeWithVersion: true}), &gorm.Config{})
+	// 模拟查询不到记录
+	db.Callback().Query().After("gorm:query").Register("test:not_found", func(tx *gorm.DB) {
+		if errors.Is(tx.Error, driver.ErrSkip) {
+			tx.Error = gorm.ErrRecordNotFound
+		}
+	})
+
+	m := gplus.GetModel[AliveMember]()
+	if res := gplus.UpsertAlive(&AliveMember{Username: "afumu", Score: 90}, []any{&m.Username}, gplus.Db(db)); res.Error != nil {
+		t.Fatalf("errors happened when UpsertAlive: %v", res.Error)
+	}
+	// 先查询未删除的记录，再按删除时间倒序查询已删除的记录，都不存在时插入
+	if len(sqls) != 4 {
+		t.Fatalf("should execute 4 sql, got %v", sqls)
+	}
+	AssertEqual(t, sqls[0], "SELECT * FROM `alive_members` WHERE `username` = ? AND `alive_members`.`deleted_at` IS NULL LIMIT 1 FOR UPDATE")
+	AssertEqual(t, sqls[1], "SELECT * FROM `alive_members` WHERE `username` = ? ORDER BY `deleted_at` DESC,`alive_members`.`id` DESC LIMIT 1 FOR UPDATE")
+	AssertEqual(t, sqls[2], "SAVEPOINT gplus_upsert_alive")
+	if !strings.HasPrefix(sqls[3], "INSERT INTO `alive_members`") {
+		t.Errorf("should insert when no record found, got %v", sqls[3])
+	}
+}
@@ -18,6 +18,7 @@
 package tests
 
 import (
+	"gorm.io/gorm"
 	"time"
 )
 
@@ -37,3 +38,33 @@ type User struct {
 func (User) TableName() string {
 	return "Users"
 }
+
+type Employee struct {
+	ID        int64
+	Name      string
+	ValidFrom time.Time
+	ValidTo   time.Time
+}
+
+type Member struct {
+	ID        int64
+	Username  string `gorm:"size:64;uniqueIndex"`
+	Score     int
+	DeletedAt gorm.DeletedAt
+}
+
+// AliveMember 用户名只在未删除的记录中唯一，通过生成列模拟部分唯一索引，已删除记录的 alive 为 NULL
+type AliveMember struct {
+	ID        int64
+	Username  string `gorm:"size:64;uniqueIndex:idx_alive_username"`
+	Alive     *bool  `gorm:"->;type:tinyint(1) GENERATED ALWAYS AS (IF(deleted_at IS NULL, 1, NULL)) STORED;uniqueIndex:idx_alive_username"`
+	Score     int
+	DeletedAt gorm.DeletedAt
+}
+
+type MemberCard struct {
+	ID       int64
+	CardNo   string
+	MemberID int64
+	Member   Member
+}
@@ -18,6 +18,8 @@
 package tests
 
 import (
+	"context"
+	"database/sql"
 	"database/sql/driver"
 	"fmt"
 	"go/ast"
@@ -140,6 +142,65 @@ func Now() *time.Time {
 	return &now
 }
 
+// openDb 打开与 gormDb 互相独立的 Db，注册插件或回调时使用，避免影响其他测试
+func openDb(t *testing.T, dialector gorm.Dialector, config *gorm.Config) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(dialector, config)
+	if err != nil {
+		t.Fatalf("errors happened when open db: %v", err)
+	}
+	return db
+}
+
+// recordingConnPool 记录执行的 SQL，不访问数据库，支持开启事务
+type recordingConnPool struct {
+	sqls *[]string
+}
+
+func (p recordingConnPool) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (p recordingConnPool) ExecContext(_ context.Context, query string, args ...any) (sql.Result, error) {
+	*p.sqls = append(*p.sqls, query)
+	return recordingResult{}, nil
+}
+
+func (p recordingConnPool) QueryContext(_ context.Context, query string, _ ...any) (*sql.Rows, error) {
+	*p.sqls = append(*p.sqls, query)
+	return nil, driver.ErrSkip
+}
+
+func (p recordingConnPool) QueryRowContext(context.Context, string, ...any) *sql.Row {
+	return nil
+}
+
+func (p recordingConnPool) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &recordingTx{p}, nil
+}
+
+type recordingTx struct {
+	recordingConnPool
+}
+
+func (*recordingTx) Commit() error {
+	return nil
+}
+
+func (*recordingTx) Rollback() error {
+	return nil
+}
+
+type recordingResult struct{}
+
+func (recordingResult) LastInsertId() (int64, error) {
+	return 1, nil
+}
+
+func (recordingResult) RowsAffected() (int64, error) {
+	return 1, nil
+}
+
 func buildSql(db *gorm.DB) string {
 	sql := db.Statement.SQL.String()
 	for _, value := range db.Statement.Vars {
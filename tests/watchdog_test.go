@@ -0,0 +1,189 @@
+/*
+ * Licensed to the AcmeStack under one or more contributor license
+ * agreements. See the NOTICE file distributed with this work for
+ * additional information regarding copyright ownership.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tests
+
+import (
+	"context"
+	"errors"
+	"github.com/acmestack/gorm-plus/gplus"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestReplaceDB(t *testing.T) {
+	db := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	old := gplus.ReplaceDB(db)
+	defer gplus.ReplaceDB(old)
+
+	if gplus.GetDB() != db {
+		t.Errorf("global db should be replaced")
+	}
+	_, resultDb := gplus.SelectList[User](nil)
+	if !resultDb.DryRun {
+		t.Errorf("should use the replaced db")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	db := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	// 旧 Db 注册的插件会注册到重建的 Db 上
+	source := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	if err := source.Use(&gplus.StatsPlugin{}); err != nil {
+		t.Fatalf("errors happened when use stats plugin: %v", err)
+	}
+	old := gplus.ReplaceDB(source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	checkErr := errors.New("access denied")
+	replaced := make(chan *gorm.DB, 1)
+	watchdog, err := gplus.StartWatchdog(ctx, gplus.WatchdogConfig{
+		Interval: 10 * time.Millisecond,
+		Check: func(ctx context.Context, current *gorm.DB) error {
+			if current == db {
+				return nil
+			}
+			return checkErr
+		},
+		Rebuild: func(ctx context.Context) (*gorm.DB, error) {
+			return db, nil
+		},
+		OnReplace: func(old, new *gorm.DB) {
+			replaced <- new
+		},
+	})
+	if err != nil {
+		cancel()
+		gplus.ReplaceDB(old)
+		t.Fatalf("errors happened when StartWatchdog: %v", err)
+	}
+	// 等待检查协程退出后再恢复全局 Db，避免未执行完的检查替换恢复后的 Db
+	defer func() {
+		cancel()
+		watchdog.Wait()
+		gplus.ReplaceDB(old)
+	}()
+
+	select {
+	case newDb := <-replaced:
+		if newDb != db || gplus.GetDB() != db {
+			t.Errorf("global db should be replaced by watchdog")
+		}
+		if _, ok := newDb.Config.Plugins["gplus:stats"]; !ok {
+			t.Errorf("plugins of old db should be registered on the rebuilt db")
+		}
+		// 默认不关闭旧 Db，替换前获取到旧 Db 的调用方仍然可以使用
+		if isDbClosed(source) {
+			t.Errorf("old db should not be closed by default")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("watchdog should replace the global db")
+	}
+
+	if _, err = gplus.StartWatchdog(ctx, gplus.WatchdogConfig{}); err == nil {
+		t.Errorf("should returns error when rebuild func is missing")
+	}
+}
+
+func TestWatchdogStop(t *testing.T) {
+	old := gplus.GetDB()
+	defer gplus.ReplaceDB(old)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rebuilding := make(chan struct{})
+	var rebuilt *gorm.DB
+	watchdog, err := gplus.StartWatchdog(ctx, gplus.WatchdogConfig{
+		Interval: 10 * time.Millisecond,
+		Check: func(ctx context.Context, current *gorm.DB) error {
+			return errors.New("access denied")
+		},
+		Rebuild: func(ctx context.Context) (db *gorm.DB, err error) {
+			// 重建期间 ctx 结束
+			close(rebuilding)
+			cancel()
+			rebuilt, err = gorm.Open(gormDb.Dialector, &gorm.Config{DryRun: true})
+			return rebuilt, err
+		},
+	})
+	if err != nil {
+		cancel()
+		t.Fatalf("errors happened when StartWatchdog: %v", err)
+	}
+
+	<-rebuilding
+	watchdog.Wait()
+	if gplus.GetDB() != old {
+		t.Errorf("global db should not be replaced after watchdog stopped")
+	}
+	// 没有使用的 Db 会被关闭
+	if !isDbClosed(rebuilt) {
+		t.Errorf("rebuilt db should be closed when not used")
+	}
+}
+
+func isDbClosed(db *gorm.DB) bool {
+	sqlDb, err := db.DB()
+	if err != nil {
+		return false
+	}
+	err = sqlDb.Ping()
+	return err != nil && err.Error() == "sql: database is closed"
+}
+
+func TestWatchdogCloseOld(t *testing.T) {
+	source := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	db := openDb(t, gormDb.Dialector, &gorm.Config{DryRun: true})
+	old := gplus.ReplaceDB(source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watchdog, err := gplus.StartWatchdog(ctx, gplus.WatchdogConfig{
+		Interval: 10 * time.Millisecond,
+		Check: func(ctx context.Context, current *gorm.DB) error {
+			if current == db {
+				return nil
+			}
+			return errors.New("access denied")
+		},
+		Rebuild: func(ctx context.Context) (*gorm.DB, error) {
+			return db, nil
+		},
+		CloseOldAfter: 20 * time.Millisecond,
+	})
+	if err != nil {
+		cancel()
+		gplus.ReplaceDB(old)
+		t.Fatalf("errors happened when StartWatchdog: %v", err)
+	}
+	defer func() {
+		cancel()
+		watchdog.Wait()
+		gplus.ReplaceDB(old)
+	}()
+
+	// 等待 CloseOldAfter 之后关闭旧 Db
+	deadline := time.Now().Add(time.Second)
+	for !isDbClosed(source) {
+		if time.Now().After(deadline) {
+			t.Fatalf("old db should be closed after CloseOldAfter")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if isDbClosed(db) {
+		t.Errorf("rebuilt db should not be closed")
+	}
+}